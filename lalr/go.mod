@@ -0,0 +1,3 @@
+module lalr
+
+go 1.24
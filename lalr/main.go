@@ -0,0 +1,438 @@
+// Lalr reads the description of a parser that goyacc writes to
+// y.output, and lists the conflicts in the grammar with an example
+// input for each, or draws the LALR automaton as a Graphviz graph.
+//
+// Usage:
+//
+//	lalr [-dot] [y.output]
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rule is a grammar rule.
+type rule struct {
+	lhs string
+	rhs []string
+}
+
+func (r rule) String() string {
+	return strings.Join(append([]string{r.lhs + ":"}, r.rhs...), " ")
+}
+
+// item is a rule with the position of the parser in it, the dot.
+// Items with the dot at the end are numbered by the rule.
+type item struct {
+	rule
+	dot int
+	n   int // rule number, -1 if the dot is not at the end
+}
+
+func (it item) String() string {
+	s := []string{it.lhs + ":"}
+	s = append(s, it.rhs[:it.dot]...)
+	s = append(s, ".")
+	s = append(s, it.rhs[it.dot:]...)
+	return strings.Join(s, " ")
+}
+
+// transition is a shift of a token or a goto on a nonterminal.
+type transition struct {
+	sym string
+	to  int
+}
+
+// conflict is a conflict on the lookahead tok between a shift to
+// the state shift, or the first of two reductions, and a reduction.
+type conflict struct {
+	tok   string
+	shift int   // 0 in reduce/reduce conflicts
+	rules []int // the rules to reduce by
+}
+
+type state struct {
+	n         int
+	items     []item // the kernel items and the complete ones
+	shifts    []transition
+	gotos     []transition
+	accept    bool
+	conflicts []conflict
+}
+
+// automaton is the LALR automaton described in y.output.
+type automaton struct {
+	states       []*state
+	rules        map[int]rule
+	nonterminals map[string]bool
+}
+
+var (
+	stateRE = regexp.MustCompile(`^state (\d+)$`)
+	srRE    = regexp.MustCompile(`^\s*(\d+): shift/reduce conflict ` +
+		`\(shift (\d+)\(\d+\), red'n (\d+)\(\d+\)\) on (.+)$`)
+	rrRE = regexp.MustCompile(`^\s*(\d+): reduce/reduce conflict\s+` +
+		`\(red'ns (\d+) and (\d+)\) on (.+)$`)
+	itemRE   = regexp.MustCompile(`^\t([^\s':]+): (.*)$`)
+	numRE    = regexp.MustCompile(`\s+\((\d+)\)$`)
+	actionRE = regexp.MustCompile(`^\t(\S+)  (shift|goto|accept) ?(\d*)`)
+)
+
+// parse reads y.output.
+func parse(r io.Reader) (*automaton, error) {
+	a := &automaton{
+		rules:        make(map[int]rule),
+		nonterminals: make(map[string]bool),
+	}
+	conflicts := make(map[int][]conflict)
+	var st *state
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if m := stateRE.FindStringSubmatch(line); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			if n != len(a.states) {
+				return nil, fmt.Errorf("state %d out of order", n)
+			}
+			st = &state{n: n}
+			a.states = append(a.states, st)
+		} else if m := srRE.FindStringSubmatch(line); m != nil {
+			n, s, r := atoi(m[1]), atoi(m[2]), atoi(m[3])
+			conflicts[n] = append(conflicts[n],
+				conflict{tok: m[4], shift: s, rules: []int{r}})
+		} else if m := rrRE.FindStringSubmatch(line); m != nil {
+			n, r1, r2 := atoi(m[1]), atoi(m[2]), atoi(m[3])
+			conflicts[n] = append(conflicts[n],
+				conflict{tok: m[4], rules: []int{r1, r2}})
+		} else if st == nil {
+			continue
+		} else if m := itemRE.FindStringSubmatch(line); m != nil {
+			it := parseItem(m[1], m[2])
+			st.items = append(st.items, it)
+			a.nonterminals[it.lhs] = true
+			if it.n >= 0 {
+				a.rules[it.n] = it.rule
+			}
+		} else if m := actionRE.FindStringSubmatch(line); m != nil {
+			t := transition{m[1], atoi(m[3])}
+			switch m[2] {
+			case "shift":
+				st.shifts = append(st.shifts, t)
+			case "goto":
+				st.gotos = append(st.gotos, t)
+				a.nonterminals[t.sym] = true
+			case "accept":
+				st.accept = true
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(a.states) == 0 {
+		return nil, fmt.Errorf("no states")
+	}
+	for n, c := range conflicts {
+		if n >= len(a.states) {
+			return nil, fmt.Errorf("conflict in unknown state %d", n)
+		}
+		a.states[n].conflicts = c
+	}
+	return a, nil
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// parseItem parses an item of the rule for lhs, like "e '+'.e"
+// or "e '+' e.    (1)".  Tokens may be quoted characters, like '.'.
+func parseItem(lhs, s string) item {
+	it := item{rule: rule{lhs: lhs}, dot: -1, n: -1}
+	s = strings.TrimSpace(s)
+	if m := numRE.FindStringSubmatch(s); m != nil {
+		it.n = atoi(m[1])
+		s = s[:len(s)-len(m[0])]
+	}
+	for i := 0; i < len(s); {
+		j := i + 1
+		switch s[i] {
+		case ' ':
+			i++
+			continue
+		case '.':
+			it.dot = len(it.rhs)
+			i++
+			continue
+		case '\'':
+			if j < len(s) && s[j] == '\\' {
+				j++
+			}
+			if k := strings.IndexByte(s[j+1:], '\''); k >= 0 {
+				j += k + 2
+			} else {
+				j = len(s)
+			}
+		default:
+			for j < len(s) && strings.IndexByte(" .'", s[j]) < 0 {
+				j++
+			}
+		}
+		it.rhs = append(it.rhs, s[i:j])
+		i = j
+	}
+	if it.dot < 0 {
+		it.dot = len(it.rhs)
+	}
+	return it
+}
+
+// yields returns the shortest string of tokens that each nonterminal
+// derives, without the error token.
+func (a *automaton) yields() map[string][]string {
+	nums := make([]int, 0, len(a.rules))
+	for n := range a.rules {
+		nums = append(nums, n)
+	}
+	sort.Ints(nums)
+	y := make(map[string][]string)
+	for changed := true; changed; {
+		changed = false
+	rules:
+		for _, n := range nums {
+			r := a.rules[n]
+			s := []string{}
+			for _, sym := range r.rhs {
+				switch {
+				case sym == "error":
+					continue rules
+				case a.nonterminals[sym]:
+					t, ok := y[sym]
+					if !ok {
+						continue rules
+					}
+					s = append(s, t...)
+				default:
+					s = append(s, sym)
+				}
+			}
+			if old, ok := y[r.lhs]; !ok || len(s) < len(old) {
+				y[r.lhs] = s
+				changed = true
+			}
+		}
+	}
+	return y
+}
+
+// prefixes returns for each state the shortest string of tokens that
+// takes the parser there from state 0.
+func (a *automaton) prefixes() [][]string {
+	y := a.yields()
+	prefix := make([][]string, len(a.states))
+	prefix[0] = []string{}
+	done := make([]bool, len(a.states))
+	for {
+		u := -1
+		for i, p := range prefix {
+			if !done[i] && p != nil && (u < 0 || len(p) < len(prefix[u])) {
+				u = i
+			}
+		}
+		if u < 0 {
+			return prefix
+		}
+		done[u] = true
+		for _, ts := range [][]transition{a.states[u].shifts, a.states[u].gotos} {
+			for _, t := range ts {
+				w := []string{t.sym}
+				if a.nonterminals[t.sym] {
+					var ok bool
+					if w, ok = y[t.sym]; !ok {
+						continue
+					}
+				} else if t.sym == "error" {
+					continue
+				}
+				if p := prefix[t.to]; p == nil || len(prefix[u])+len(w) < len(p) {
+					prefix[t.to] = append(append([]string{}, prefix[u]...), w...)
+				}
+			}
+		}
+	}
+}
+
+// report lists the conflicts of the states, grouping those that only
+// differ in the lookahead token, like:
+//
+//	state 12: shift/reduce conflict on ELSE
+//		shift 14:  stmt: IF expr stmt . ELSE stmt
+//		reduce 5:  stmt: IF expr stmt
+//		example:   IF NUM IDENT . ELSE
+func (a *automaton) report(w io.Writer) {
+	var prefix [][]string
+	sr, rr := 0, 0
+	for _, st := range a.states {
+		var groups [][]conflict
+		for _, c := range st.conflicts {
+			if c.shift == 0 {
+				rr++
+			} else {
+				sr++
+			}
+			if g := len(groups) - 1; g >= 0 && sameActions(groups[g][0], c) {
+				groups[g] = append(groups[g], c)
+			} else {
+				groups = append(groups, []conflict{c})
+			}
+		}
+		if len(groups) > 0 && prefix == nil {
+			prefix = a.prefixes()
+		}
+		for _, g := range groups {
+			c := g[0]
+			kind := "shift/reduce"
+			if c.shift == 0 {
+				kind = "reduce/reduce"
+			}
+			toks := make([]string, len(g))
+			for i, c := range g {
+				toks[i] = c.tok
+			}
+			fmt.Fprintf(w, "state %d: %s conflict on %s\n",
+				st.n, kind, strings.Join(toks, ", "))
+			if c.shift != 0 {
+				shifted := false
+				for _, it := range st.items {
+					if it.dot < len(it.rhs) && it.rhs[it.dot] == c.tok {
+						fmt.Fprintf(w, "\tshift %d:  %v\n", c.shift, it)
+						shifted = true
+					}
+				}
+				if !shifted {
+					fmt.Fprintf(w, "\tshift %d\n", c.shift)
+				}
+			}
+			for _, r := range c.rules {
+				fmt.Fprintf(w, "\treduce %d:  %v\n", r, a.rules[r])
+			}
+			if p := prefix[st.n]; p != nil {
+				ex := append(append([]string{}, p...), ".", c.tok)
+				fmt.Fprintf(w, "\texample:   %s\n", strings.Join(ex, " "))
+			}
+		}
+	}
+	if sr == 0 && rr == 0 {
+		fmt.Fprintln(w, "no conflicts")
+		return
+	}
+	fmt.Fprintf(w, "%d shift/reduce, %d reduce/reduce conflicts\n", sr, rr)
+}
+
+func sameActions(c, d conflict) bool {
+	if c.shift != d.shift || len(c.rules) != len(d.rules) {
+		return false
+	}
+	for i := range c.rules {
+		if c.rules[i] != d.rules[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// dot writes the automaton as a Graphviz graph, with the items in
+// the states, the shifts in black, the gotos in blue and the states
+// with conflicts in red.
+func (a *automaton) dot(w io.Writer) {
+	fmt.Fprintln(w, "digraph {")
+	fmt.Fprintln(w, "        node [shape=\"box\"]")
+	fmt.Fprintln(w, "        start [style=\"invis\", shape=\"point\"]")
+	for _, st := range a.states {
+		label := fmt.Sprintf("state %d\\l", st.n)
+		for _, it := range st.items {
+			label += escape(it.String())
+			if it.n >= 0 {
+				label += fmt.Sprintf("    (%d)", it.n)
+			}
+			label += "\\l"
+		}
+		attrs := ""
+		if len(st.conflicts) > 0 {
+			attrs = `, color="red"`
+		}
+		fmt.Fprintf(w, "        s%d [label=\"%s\"%s]\n", st.n, label, attrs)
+	}
+	fmt.Fprintln(w, "        done [label=\"accept\", peripheries=2]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "        start->s0")
+	for _, st := range a.states {
+		for _, t := range st.shifts {
+			fmt.Fprintf(w, "        s%d->s%d [label=\" %s \"]\n",
+				st.n, t.to, escape(t.sym))
+		}
+		for _, t := range st.gotos {
+			fmt.Fprintf(w, "        s%d->s%d [label=\" %s \", color=\"blue\"]\n",
+				st.n, t.to, escape(t.sym))
+		}
+		if st.accept {
+			fmt.Fprintf(w, "        s%d->done [label=\" $end \"]\n", st.n)
+		}
+	}
+	fmt.Fprintln(w, "}")
+}
+
+// escape escapes s for a quoted string in a Graphviz file.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
+
+var dotFlag = flag.Bool("dot", false,
+	"draw the automaton as a Graphviz graph instead of listing conflicts")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: lalr [-dot] [y.output]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	name := "y.output"
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		name = flag.Arg(0)
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	a, err := parse(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		os.Exit(1)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	if *dotFlag {
+		a.dot(w)
+	} else {
+		a.report(w)
+	}
+	if err := w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
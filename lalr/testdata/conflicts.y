@@ -0,0 +1,28 @@
+%{
+package main
+%}
+
+// A grammar with the classic conflicts: binary operators without
+// precedence and the dangling else are shift/reduce conflicts, and
+// a number that reduces to both an expression and an atom is
+// a reduce/reduce conflict.
+
+%token NUM IDENT IF ELSE
+
+%%
+
+expr:
+        expr '+' expr
+|       expr '*' expr
+|       NUM
+|       stmt
+
+stmt:
+        IF expr stmt
+|       IF expr stmt ELSE stmt
+|       IDENT
+|       atom
+
+atom:   NUM
+
+%%
@@ -0,0 +1,3 @@
+module railroad
+
+go 1.24
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// rule is a grammar rule with its alternatives, each a list of
+// symbols.
+type rule struct {
+	name string
+	alts [][]string
+}
+
+// grammar is the rules of a goyacc grammar, in the order of the
+// source.
+type grammar struct {
+	rules  []*rule
+	byName map[string]*rule
+}
+
+// isQuoted reports whether the symbol s is a character token, like '+'.
+func isQuoted(s string) bool {
+	return len(s) >= 3 && s[0] == '\''
+}
+
+// unquote returns the character of the token s, like "+" for '+'.
+func unquote(s string) string {
+	s = s[1 : len(s)-1]
+	if len(s) == 2 && s[0] == '\\' {
+		switch s[1] {
+		case 'n':
+			return "\n"
+		case 't':
+			return "\t"
+		}
+		return s[1:]
+	}
+	return s
+}
+
+// parseGrammar parses the rules between the %% markers of a goyacc
+// file, leaving out the actions, %prec and the alternatives with the
+// error token, which are for error recovery.
+func parseGrammar(src string) (*grammar, error) {
+	start := strings.Index(src, "\n%%")
+	if start < 0 {
+		return nil, fmt.Errorf("no %%%% marker")
+	}
+	src = src[start+3:]
+	if end := strings.Index(src, "\n%%"); end >= 0 {
+		src = src[:end]
+	}
+	toks, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	g := &grammar{byName: make(map[string]*rule)}
+	var (
+		r   *rule
+		alt []string
+		bad bool // the alternative has the error token
+	)
+	endAlt := func() {
+		if r != nil && !bad {
+			r.alts = append(r.alts, alt)
+		}
+		alt, bad = nil, false
+	}
+	for i := 0; i < len(toks); i++ {
+		switch t := toks[i]; {
+		case i+1 < len(toks) && toks[i+1] == ":":
+			endAlt()
+			if r = g.byName[t]; r == nil {
+				r = &rule{name: t}
+				g.rules = append(g.rules, r)
+				g.byName[t] = r
+			}
+			i++
+		case r == nil:
+			return nil, fmt.Errorf("unexpected %s before the first rule", t)
+		case t == "|":
+			endAlt()
+		case t == ";":
+			endAlt()
+			r = nil
+		case t == "%prec":
+			i++
+		case t == "error":
+			bad = true
+		default:
+			alt = append(alt, t)
+		}
+	}
+	endAlt()
+	return g, nil
+}
+
+// tokenize splits the rules section of a grammar into symbols and
+// the punctuation ':', '|' and ';', skipping comments and actions.
+func tokenize(src string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case unicode.IsSpace(rune(c)):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			i = skipTo(src, i, "\n")
+		case strings.HasPrefix(src[i:], "/*"):
+			i = skipTo(src, i+2, "*/")
+		case c == '{':
+			j, err := skipAction(src, i)
+			if err != nil {
+				return nil, err
+			}
+			i = j
+		case c == ':' || c == '|' || c == ';':
+			toks = append(toks, string(c))
+			i++
+		case c == '\'':
+			j := i + 2
+			if i+1 < len(src) && src[i+1] == '\\' {
+				j++
+			}
+			if j >= len(src) || src[j] != '\'' {
+				return nil, fmt.Errorf("bad character literal at %q", line(src, i))
+			}
+			toks = append(toks, src[i:j+1])
+			i = j + 1
+		case c == '%' || c == '_' || c == '.' || c == '$' ||
+			unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] == '.' ||
+				unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, src[i:j])
+			i = j
+		default:
+			return nil, fmt.Errorf("unexpected %q at %q", c, line(src, i))
+		}
+	}
+	return toks, nil
+}
+
+// skipTo returns the offset in src after the first end from i on.
+func skipTo(src string, i int, end string) int {
+	if j := strings.Index(src[i:], end); j >= 0 {
+		return i + j + len(end)
+	}
+	return len(src)
+}
+
+// skipAction returns the offset in src after the action at i,
+// which is Go code in braces.
+func skipAction(src string, i int) (int, error) {
+	depth := 0
+	for j := i; j < len(src); j++ {
+		switch src[j] {
+		case '{':
+			depth++
+		case '}':
+			if depth--; depth == 0 {
+				return j + 1, nil
+			}
+		case '"', '\'', '`':
+			// skip strings and runes, which may have braces
+			q := src[j]
+			for j++; j < len(src) && src[j] != q; j++ {
+				if src[j] == '\\' && q != '`' {
+					j++
+				}
+			}
+		case '/':
+			if strings.HasPrefix(src[j:], "//") {
+				j = skipTo(src, j, "\n") - 1
+			} else if strings.HasPrefix(src[j:], "/*") {
+				j = skipTo(src, j+2, "*/") - 1
+			}
+		}
+	}
+	return 0, fmt.Errorf("unterminated action at %q", line(src, i))
+}
+
+// line returns the rest of the line of src at i, for error messages.
+func line(src string, i int) string {
+	s := src[i:]
+	if j := strings.IndexByte(s, '\n'); j >= 0 {
+		s = s[:j]
+	}
+	return s
+}
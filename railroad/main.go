@@ -0,0 +1,191 @@
+// Railroad reads the grammar of a goyacc file and draws its rules as
+// railroad diagrams in SVG, or prints them in EBNF, the notation of
+// the Go specification.  The actions are left out, and so are the
+// alternatives with the error token, which are only there for error
+// recovery.  Left recursive rules become repetitions, and rules with
+// an empty alternative become options.
+//
+// Usage:
+//
+//	railroad [-ebnf] [-rule name] [parse.y]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// expr is the right side of a rule in EBNF.
+type expr interface{}
+
+type (
+	// symbol is a token, like NUM or '+', or a nonterminal.
+	symbol struct {
+		name string
+		term bool
+	}
+	sequence []expr           // the expressions one after another
+	choice   []expr           // one of the expressions, at least two
+	option   struct{ x expr } // [x]
+	repeat   struct{ x expr } // {x}
+)
+
+// expr returns the right side of the rule r, with left recursion
+// turned into repetition:
+//
+//	a: b | a c | a d  →  a = b { c | d } .
+func (g *grammar) expr(r *rule) expr {
+	var base, rec []expr
+	for _, alt := range r.alts {
+		if len(alt) > 0 && alt[0] == r.name {
+			rec = append(rec, g.sequence(alt[1:]))
+		} else {
+			base = append(base, g.sequence(alt))
+		}
+	}
+	b := alternatives(base)
+	if len(rec) == 0 {
+		return b
+	}
+	loop := repeat{alternatives(rec)}
+	if isEmpty(b) {
+		return loop
+	}
+	return sequence{b, loop}
+}
+
+func (g *grammar) sequence(syms []string) expr {
+	seq := make(sequence, len(syms))
+	for i, s := range syms {
+		seq[i] = symbol{s, g.byName[s] == nil}
+	}
+	if len(seq) == 1 {
+		return seq[0]
+	}
+	return seq
+}
+
+// alternatives returns the choice between xs, which is an option
+// if one of them is empty.
+func alternatives(xs []expr) expr {
+	var rest choice
+	for _, x := range xs {
+		if !isEmpty(x) {
+			rest = append(rest, x)
+		}
+	}
+	var x expr = rest
+	switch len(rest) {
+	case 0:
+		return sequence{}
+	case 1:
+		x = rest[0]
+	}
+	if len(rest) < len(xs) {
+		return option{x}
+	}
+	return x
+}
+
+func isEmpty(x expr) bool {
+	seq, ok := x.(sequence)
+	return ok && len(seq) == 0
+}
+
+// ebnf returns x in EBNF.  Choices in sequences are parenthesised.
+func ebnf(x expr) string {
+	switch x := x.(type) {
+	case symbol:
+		if isQuoted(x.name) {
+			return fmt.Sprintf("%q", unquote(x.name))
+		}
+		return x.name
+	case sequence:
+		s := make([]string, len(x))
+		for i, x := range x {
+			s[i] = ebnf(x)
+			if _, ok := x.(choice); ok {
+				s[i] = "( " + s[i] + " )"
+			}
+		}
+		return strings.Join(s, " ")
+	case choice:
+		s := make([]string, len(x))
+		for i, x := range x {
+			s[i] = ebnf(x)
+		}
+		return strings.Join(s, " | ")
+	case option:
+		return "[ " + ebnf(x.x) + " ]"
+	case repeat:
+		return "{ " + ebnf(x.x) + " }"
+	}
+	panic(fmt.Sprintf("unknown expression %T", x))
+}
+
+var (
+	ebnfFlag = flag.Bool("ebnf", false, "print the grammar in EBNF")
+	ruleFlag = flag.String("rule", "",
+		"only print or draw the rule for the nonterminal `name`")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"usage: %s [-ebnf] [-rule name] [parse.y]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	in := os.Stdin
+	switch flag.NArg() {
+	case 0:
+	case 1:
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		in = f
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	src, err := io.ReadAll(in)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	g, err := parseGrammar(string(src))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", in.Name(), err)
+		os.Exit(1)
+	}
+	rules := g.rules
+	if *ruleFlag != "" {
+		r := g.byName[*ruleFlag]
+		if r == nil {
+			fmt.Fprintf(os.Stderr, "%s: no rule for %s\n",
+				in.Name(), *ruleFlag)
+			os.Exit(1)
+		}
+		rules = []*rule{r}
+	}
+	if *ebnfFlag {
+		for _, r := range rules {
+			if x := g.expr(r); isEmpty(x) {
+				fmt.Printf("%s = .\n", r.name)
+			} else {
+				fmt.Printf("%s = %s .\n", r.name, ebnf(x))
+			}
+		}
+		return
+	}
+	if err := writeSVG(os.Stdout, g, rules); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"html"
+	"io"
+)
+
+// Sizes in the diagrams, in pixels.
+const (
+	boxHeight = 24
+	charWidth = 8  // of the monospace font
+	arc       = 10 // radius of the curves
+	gap       = 10 // between the elements of a sequence
+	vgap      = 8  // between the alternatives of a choice
+	margin    = 20
+	titleSize = 24 // space for the name of the rule
+)
+
+// A diagram is drawn on a line running through it from left to
+// right.  Its size is its width and how far it reaches above and
+// below the line.
+type diagram interface {
+	size() (w, up, down int)
+	// draw draws the diagram with the line entering it at (x, y)
+	draw(s *svg, x, y int)
+}
+
+type (
+	box struct {
+		text   string
+		term   bool
+		target string // the rule to link to
+	}
+	seqDiagram    []diagram
+	choiceDiagram []diagram // the first alternative on the line
+	loopDiagram   struct{ d diagram }
+)
+
+// newDiagram returns the diagram of x.  An option is a choice with
+// an empty alternative, and a repetition is an option of a loop.
+func newDiagram(x expr) diagram {
+	switch x := x.(type) {
+	case symbol:
+		if x.term {
+			text := x.name
+			if isQuoted(text) {
+				text = unquote(text)
+			}
+			return box{text: text, term: true}
+		}
+		return box{text: x.name, target: x.name}
+	case sequence:
+		seq := make(seqDiagram, len(x))
+		for i, x := range x {
+			seq[i] = newDiagram(x)
+		}
+		return seq
+	case choice:
+		c := make(choiceDiagram, len(x))
+		for i, x := range x {
+			c[i] = newDiagram(x)
+		}
+		return c
+	case option:
+		return choiceDiagram{seqDiagram{}, newDiagram(x.x)}
+	case repeat:
+		return choiceDiagram{seqDiagram{}, loopDiagram{newDiagram(x.x)}}
+	}
+	panic(fmt.Sprintf("unknown expression %T", x))
+}
+
+func (b box) size() (w, up, down int) {
+	return len(b.text)*charWidth + 2*gap, boxHeight / 2, boxHeight / 2
+}
+
+func (b box) draw(s *svg, x, y int) {
+	w, _, _ := b.size()
+	class, r := "nonterminal", 0
+	if b.term {
+		class, r = "terminal", boxHeight/2
+	}
+	if b.target != "" {
+		s.printf("<a href=\"#%s\">\n", html.EscapeString(b.target))
+	}
+	s.printf("<rect class=%q x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" rx=\"%d\"/>\n",
+		class, x, y-boxHeight/2, w, boxHeight, r)
+	s.printf("<text x=\"%d\" y=\"%d\">%s</text>\n",
+		x+w/2, y+4, html.EscapeString(b.text))
+	if b.target != "" {
+		s.printf("</a>\n")
+	}
+}
+
+func (seq seqDiagram) size() (w, up, down int) {
+	for i, d := range seq {
+		dw, du, dd := d.size()
+		if i > 0 {
+			w += gap
+		}
+		w += dw
+		up, down = max(up, du), max(down, dd)
+	}
+	return w, up, down
+}
+
+func (seq seqDiagram) draw(s *svg, x, y int) {
+	for i, d := range seq {
+		if i > 0 {
+			s.line(x, y, x+gap, y)
+			x += gap
+		}
+		d.draw(s, x, y)
+		w, _, _ := d.size()
+		x += w
+	}
+}
+
+// offsets returns how far below the line each alternative of c is.
+func (c choiceDiagram) offsets() []int {
+	off := make([]int, len(c))
+	for i := 1; i < len(c); i++ {
+		_, _, down := c[i-1].size()
+		_, up, _ := c[i].size()
+		off[i] = off[i-1] + down + vgap + max(up, arc)
+	}
+	return off
+}
+
+func (c choiceDiagram) size() (w, up, down int) {
+	for _, d := range c {
+		dw, _, _ := d.size()
+		w = max(w, dw)
+	}
+	off := c.offsets()
+	_, up, _ = c[0].size()
+	_, _, down = c[len(c)-1].size()
+	return w + 4*arc, up, off[len(c)-1] + down
+}
+
+func (c choiceDiagram) draw(s *svg, x, y int) {
+	w, _, _ := c.size()
+	for i, d := range c {
+		dy := y + c.offsets()[i]
+		dw, _, _ := d.size()
+		if i == 0 {
+			s.line(x, y, x+2*arc, y)
+		} else {
+			// down from the entry on the left, up to the exit on
+			// the right
+			s.printf("<path d=\"M%d %d a%d %d 0 0 1 %d %d v%d a%d %d 0 0 0 %d %d\"/>\n",
+				x, y, arc, arc, arc, arc, dy-y-2*arc, arc, arc, arc, arc)
+			s.printf("<path d=\"M%d %d a%d %d 0 0 0 %d %d v%d a%d %d 0 0 1 %d %d\"/>\n",
+				x+w-2*arc, dy, arc, arc, arc, -arc, -(dy - y - 2*arc), arc, arc, arc, -arc)
+		}
+		d.draw(s, x+2*arc, dy)
+		s.line(x+2*arc+dw, dy, x+w-2*arc, dy)
+		if i == 0 {
+			s.line(x+w-2*arc, y, x+w, y)
+		}
+	}
+}
+
+func (l loopDiagram) size() (w, up, down int) {
+	w, up, down = l.d.size()
+	return w + 2*arc, up, down + vgap + arc
+}
+
+// draw draws the loop as the diagram on the line with a way back
+// under it.
+func (l loopDiagram) draw(s *svg, x, y int) {
+	w, _, down := l.d.size()
+	s.line(x, y, x+arc, y)
+	l.d.draw(s, x+arc, y)
+	s.line(x+arc+w, y, x+2*arc+w, y)
+	back := y + down + vgap
+	s.printf("<path d=\"M%d %d a%d %d 0 0 1 %d %d v%d a%d %d 0 0 1 %d %d h%d a%d %d 0 0 1 %d %d v%d a%d %d 0 0 1 %d %d\"/>\n",
+		x+arc+w, y, arc, arc, arc, arc, back-y-arc, arc, arc, -arc, arc,
+		-w, arc, arc, -arc, -arc, -(back - y - arc), arc, arc, arc, -arc)
+}
+
+// svg writes an SVG document.
+type svg struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (s *svg) printf(format string, args ...interface{}) {
+	if s.err == nil {
+		_, s.err = fmt.Fprintf(s.w, format, args...)
+	}
+}
+
+func (s *svg) line(x1, y1, x2, y2 int) {
+	if x1 != x2 || y1 != y2 {
+		s.printf("<path d=\"M%d %d H%d\"/>\n", x1, y1, x2)
+	}
+}
+
+const svgStyle = `<style>
+path { fill: none; stroke: black; stroke-width: 1.5 }
+rect { fill: #ffd; stroke: black; stroke-width: 1.5 }
+rect.terminal { fill: #dfd }
+text { font: 13px monospace; text-anchor: middle }
+text.rule { font: bold 14px sans-serif; text-anchor: start }
+</style>
+`
+
+// writeSVG writes the diagrams of rules in g to w, one under
+// another, with the name of the rule over each.  The nonterminals
+// link to the diagrams of their rules.
+func writeSVG(w io.Writer, g *grammar, rules []*rule) error {
+	ds := make([]diagram, len(rules))
+	width, height := 0, margin
+	for i, r := range rules {
+		ds[i] = newDiagram(g.expr(r))
+		dw, up, down := ds[i].size()
+		width = max(width, dw+2*arc+2*margin)
+		height += titleSize + up + down + margin
+	}
+	s := &svg{w: bufio.NewWriter(w)}
+	s.printf("<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		width, height, width, height)
+	s.printf("%s", svgStyle)
+	y := margin
+	for i, r := range rules {
+		dw, up, down := ds[i].size()
+		s.printf("<g id=\"%s\">\n", html.EscapeString(r.name))
+		s.printf("<text class=\"rule\" x=\"%d\" y=\"%d\">%s</text>\n",
+			margin, y+titleSize/2, html.EscapeString(r.name))
+		y += titleSize + up
+		// the ends of the rule are bars across the line
+		s.printf("<path d=\"M%d %d v%d\"/>\n", margin, y-arc/2, arc)
+		s.line(margin, y, margin+arc, y)
+		ds[i].draw(s, margin+arc, y)
+		s.line(margin+arc+dw, y, margin+2*arc+dw, y)
+		s.printf("<path d=\"M%d %d v%d\"/>\n", margin+2*arc+dw, y-arc/2, arc)
+		s.printf("</g>\n")
+		y += down + margin
+	}
+	s.printf("</svg>\n")
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
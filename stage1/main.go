@@ -5,11 +5,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type tree struct {
@@ -118,7 +120,7 @@ func (t *tree) print(pref []byte, last bool) {
 	case IDENT:
 		fmt.Println("--> ident", t.s)
 	default:
-		fmt.Println("+-> op", string(t.typ))
+		fmt.Println("+-> op", string(rune(t.typ)))
 		pref = append(pref, wall, ' ', ' ', ' ')
 		if t.left != nil {
 			t.left.print(pref, false)
@@ -147,7 +149,79 @@ func (l list) print() {
 	}
 }
 
+type varMap map[string]int
+
+func (vl varMap) Get(s string) int {
+	if n, ok := vl[s]; ok {
+		return n
+	}
+	fmt.Fprintln(os.Stderr, "unknown variable", s)
+	return 0
+}
+
+var vars = make(varMap)
+
+// eval interprets the tree t by walking it, with the same semantics
+// as the closures of stage 2.
+func (t *tree) eval() int {
+	switch t.typ {
+	case NUM:
+		return t.n
+	case IDENT:
+		return vars.Get(t.s)
+	case '=':
+		vars[t.left.s] = t.right.eval()
+		return 0
+	case '+':
+		return t.left.eval() + t.right.eval()
+	case '-':
+		if t.left == nil {
+			return -t.right.eval()
+		}
+		return t.left.eval() - t.right.eval()
+	case '*':
+		return t.left.eval() * t.right.eval()
+	}
+	x, y := t.left.eval(), t.right.eval()
+	if y == 0 {
+		fmt.Fprintln(os.Stderr, "division by zero")
+		return 0
+	}
+	if t.typ == '/' {
+		return x / y
+	}
+	return x % y
+}
+
+// eval runs the statements in l, printing the values of expressions.
+func (l list) eval() {
+	for _, v := range l {
+		if v.typ == '=' {
+			v.eval()
+		} else {
+			fmt.Println(v.eval())
+		}
+	}
+}
+
+// timeRun runs f n times and prints the total run time.
+func timeRun(n int, f func()) {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		f()
+	}
+	fmt.Fprintln(os.Stderr, "run time:", time.Since(start))
+}
+
+var (
+	evalFlag = flag.Bool("eval", false,
+		"evaluate the parse tree instead of printing it")
+	repeat = flag.Int("n", 0,
+		"evaluate the parse tree `n` times and print the run time")
+)
+
 func main() {
+	flag.Parse()
 	yyErrorVerbose = true
 	yy := yyLex{
 		c: make(chan token),
@@ -162,5 +236,12 @@ func main() {
 	}
 	go yy.run(os.Stdin)
 	yyParse(&yy)
-	top.print()
+	switch {
+	case *repeat > 0:
+		timeRun(*repeat, top.eval)
+	case *evalFlag:
+		top.eval()
+	default:
+		top.print()
+	}
 }
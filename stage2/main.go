@@ -5,11 +5,13 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type list []func() int
@@ -119,7 +121,20 @@ func (yy *yyLex) run(in io.Reader) {
 	yy.sendToken(token{})
 }
 
+// timeRun runs f n times and prints the total run time.
+func timeRun(n int, f func()) {
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		f()
+	}
+	fmt.Fprintln(os.Stderr, "run time:", time.Since(start))
+}
+
+var repeat = flag.Int("n", 0,
+	"run the program `n` times and print the run time")
+
 func main() {
+	flag.Parse()
 	yyErrorVerbose = true
 	yy := yyLex{
 		c: make(chan token),
@@ -135,5 +150,9 @@ func main() {
 	}
 	go yy.run(os.Stdin)
 	yyParse(&yy)
+	if *repeat > 0 {
+		timeRun(*repeat, runtime.top.Run)
+		return
+	}
 	runtime.top.Run()
 }
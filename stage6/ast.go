@@ -0,0 +1,157 @@
+package main
+
+// node is a node of the abstract syntax tree built by the parser.
+// Pos and End return the positions of the first character of the
+// node and of the character just after it.
+type node interface {
+	Pos() pos
+	End() pos
+}
+
+type (
+	// numLit is a number literal.
+	numLit struct {
+		tok token
+	}
+
+	// ident is a variable reference.
+	ident struct {
+		tok token
+	}
+
+	// unary is a unary operator applied to x.
+	unary struct {
+		op token
+		x  node
+	}
+
+	// binary is a binary operator applied to x and y,
+	// including short-circuit logic and comparison.
+	binary struct {
+		op   token
+		x, y node
+	}
+
+	// paren is a parenthesised expression.
+	paren struct {
+		lparen, rparen pos
+		x              node
+	}
+
+	// assign is an assignment to the variable name.
+	// For '=' op.op is nil, for "++" and "--" rval is nil.
+	assign struct {
+		name token
+		op   token
+		rval node
+	}
+
+	// printStmt is an expression statement, which prints its value.
+	printStmt struct {
+		x node
+	}
+
+	// forStmt is a for loop.  init and post are nil in the
+	// "for cond { ... }" form.
+	forStmt struct {
+		tok              token
+		init, cond, post node
+		body             *block
+	}
+
+	// block is a list of statements.  The whole program is
+	// a block as well, without braces.
+	block struct {
+		lbrace, rbrace pos
+		stmts          []node
+	}
+
+	// command is a command sent by the lexer, like EOF.
+	command struct {
+		tok token
+	}
+)
+
+func (n *numLit) Pos() pos    { return n.tok.pos }
+func (n *ident) Pos() pos     { return n.tok.pos }
+func (n *unary) Pos() pos     { return n.op.pos }
+func (n *binary) Pos() pos    { return n.x.Pos() }
+func (n *paren) Pos() pos     { return n.lparen }
+func (n *assign) Pos() pos    { return n.name.pos }
+func (n *printStmt) Pos() pos { return n.x.Pos() }
+func (n *forStmt) Pos() pos   { return n.tok.pos }
+func (n *command) Pos() pos   { return n.tok.pos }
+
+func (n *block) Pos() pos {
+	if !n.lbrace.IsValid() && len(n.stmts) > 0 {
+		return n.stmts[0].Pos()
+	}
+	return n.lbrace
+}
+
+func (n *numLit) End() pos    { return n.tok.end }
+func (n *ident) End() pos     { return n.tok.end }
+func (n *unary) End() pos     { return n.x.End() }
+func (n *binary) End() pos    { return n.y.End() }
+func (n *paren) End() pos     { return pos{n.rparen.file, n.rparen.line, n.rparen.col + 1} }
+func (n *printStmt) End() pos { return n.x.End() }
+func (n *forStmt) End() pos   { return n.body.End() }
+func (n *command) End() pos   { return n.tok.end }
+
+func (n *assign) End() pos {
+	if n.rval == nil {
+		return n.op.end
+	}
+	return n.rval.End()
+}
+
+func (n *block) End() pos {
+	end := n.rbrace
+	switch {
+	case end.IsValid():
+		end.col++
+	case len(n.stmts) > 0:
+		end = n.stmts[len(n.stmts)-1].End()
+	}
+	return end
+}
+
+// span returns the part of n shown in diagnostics, which is all of
+// it except for the body of for loops.
+func span(n node) (start, end pos) {
+	if n, ok := n.(*forStmt); ok {
+		return n.Pos(), n.body.Pos()
+	}
+	return n.Pos(), n.End()
+}
+
+// what returns a description of n for error traces.
+func what(n node) string {
+	switch n := n.(type) {
+	case *numLit:
+		return "number"
+	case *ident:
+		return "variable " + n.tok.s
+	case *unary:
+		return "unary " + n.op.s
+	case *binary:
+		switch n.op.typ {
+		case '/':
+			return "division"
+		case '%':
+			return "remainder"
+		}
+		return "binary " + n.op.s
+	case *paren:
+		return "parenthesised expression"
+	case *assign:
+		return "assignment to " + n.name.s
+	case *printStmt:
+		return "expression statement"
+	case *forStmt:
+		return "for loop"
+	case *block:
+		return "block"
+	}
+	return "command"
+}
@@ -0,0 +1,30 @@
+# bench.awk summarises the "run time: d" lines -time prints for
+# several runs of a program as the minimum, median and maximum,
+# in milliseconds, as the run time varies from run to run.
+
+/^run time: / {
+	d = $3
+	if (d ~ /ms$/)
+		ms = substr(d, 1, length(d) - 2)
+	else if (d ~ /(µs|us)$/)
+		ms = substr(d, 1, length(d) - 2) / 1000
+	else if (d ~ /ns$/)
+		ms = substr(d, 1, length(d) - 2) / 1000000
+	else if (d ~ /m[0-9.]+s$/) {
+		split(d, a, "m")
+		ms = (a[1] * 60 + substr(a[2], 1, length(a[2]) - 1)) * 1000
+	} else
+		ms = substr(d, 1, length(d) - 1) * 1000
+	ms += 0
+	# insertion sort
+	for (i = n++; i > 0 && t[i - 1] > ms; i--)
+		t[i] = t[i - 1]
+	t[i] = ms
+}
+
+END {
+	if (n == 0)
+		exit 1
+	printf "%d runs: min %.1fms median %.1fms max %.1fms\n",
+	    n, t[0], t[int(n / 2)], t[n - 1]
+}
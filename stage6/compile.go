@@ -0,0 +1,145 @@
+package main
+
+// compiler turns the abstract syntax tree into closures.
+type compiler struct {
+	types *typeInfo // nil if closures are not specialised
+}
+
+// compile turns the abstract syntax tree n into a closure.
+func compile(n node) fun {
+	var c compiler
+	if *typedFlag {
+		c.types = inferTypes(n)
+	}
+	return c.compile(n)
+}
+
+func (c *compiler) compile(n node) fun {
+	switch n := n.(type) {
+	case *numLit:
+		return n.tok.n.NewFun()
+	case *ident:
+		// like at(n, runtime.vars.NewGet(n.tok.s)), without
+		// the cost of another call for every variable reference
+		vf, i := &runtime.vars, runtime.vars.slot(n.tok.s)
+		return func() (number, error) {
+			a, err := vf.get(i)
+			if err != nil {
+				err = withPos(n, err)
+			}
+			return a, err
+		}
+	case *unary:
+		if a, ok := constNum(n); ok {
+			return a.NewFun()
+		}
+		return n.op.op.NewFun(c.compile(n.x), nil)
+	case *paren:
+		return c.compile(n.x)
+	case *binary:
+		f := c.newBinFun(n.op.op, n.x, n.y, c.compile(n.x), c.compile(n.y))
+		if _, ok := n.op.op.(divModOp); ok {
+			return at(n, f)
+		}
+		return f
+	case *assign:
+		var rval fun
+		get := &ident{tok: n.name}
+		switch {
+		case n.op.op == nil:
+			rval = c.compile(n.rval)
+		case n.rval == nil:
+			rval = n.op.op.NewFun(c.compile(get), nil)
+		default:
+			rval = c.newBinFun(n.op.op, get, n.rval,
+				c.compile(get), c.compile(n.rval))
+		}
+		return at(n, NewAssign(n.name.s, nil, rval))
+	case *printStmt:
+		return at(n, printOp.NewFun(c.compile(n.x), nil))
+	case *forStmt:
+		if n.init != nil {
+			return list{at(n, c.compile(n.init)), c.compileLoop(n)}.NewFun()
+		}
+		return c.compileLoop(n)
+	case *block:
+		return c.compileList(n.stmts).NewFun()
+	case *command:
+		return n.tok.fun
+	}
+	panic("compile: unknown node")
+}
+
+// newBinFun makes a closure for the binary operator o applied to
+// expressions x and y, compiled into left and right.  The closure is
+// specialised if x and y are of the same known type.
+func (c *compiler) newBinFun(o op, x, y node, left, right fun) fun {
+	if c.types != nil {
+		t, u := c.types.of(x), c.types.of(y)
+		if o, ok := o.(typedOp); ok && isStatic(t, u) {
+			return o.NewTypedFun(t, left, right)
+		}
+	}
+	return o.NewFun(left, right)
+}
+
+// compileList compiles a list of statements, flattening nested blocks
+// and moving for loop initialisation statements out of the loops.
+func (c *compiler) compileList(stmts []node) list {
+	l := make(list, 0, len(stmts))
+	for _, v := range stmts {
+		switch v := v.(type) {
+		case *block:
+			l = append(l, c.compileList(v.stmts)...)
+		case *forStmt:
+			if v.init != nil {
+				l = append(l, at(v, c.compile(v.init)))
+			}
+			l = append(l, c.compileLoop(v))
+		default:
+			l = append(l, c.compile(v))
+		}
+	}
+	return l
+}
+
+// compileLoop compiles a for loop without its initialisation
+// statement.  The post statement runs at the end of the block.
+func (c *compiler) compileLoop(n *forStmt) fun {
+	body := c.compileList(n.body.stmts)
+	if n.post != nil {
+		body = append(body, c.compile(n.post))
+	}
+	return at(n, n.tok.op.NewFun(c.compile(n.cond), body.NewFun()))
+}
+
+// at returns a closure calling f, which gives the errors returned by f
+// the position of n.  Errors are reported at the innermost variable
+// reference, division or statement, and traced through the enclosing
+// divisions, statements and loops.
+func at(n node, f fun) fun {
+	return func() (number, error) {
+		a, err := f()
+		if err != nil {
+			err = withPos(n, err)
+		}
+		return a, err
+	}
+}
+
+// constNum returns the value of n if it's a number literal preceded
+// by zero or more unary operators.  Such numbers are computed at
+// compile time.
+func constNum(n node) (number, bool) {
+	switch n := n.(type) {
+	case *numLit:
+		return n.tok.n, true
+	case *unary:
+		if a, ok := constNum(n.x); ok {
+			return a.RunUnary(n.op.op), true
+		}
+	case *paren:
+		return constNum(n.x)
+	}
+	return number{}, false
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattn/go-isatty"
+)
+
+var (
+	caretFlag = flag.Bool("caret", true,
+		"show the source line and a caret under the position of errors")
+	traceFlag = flag.Bool("trace", true,
+		"show the constructs enclosing runtime errors")
+	colorFlag = flag.String("color", "auto",
+		"colour diagnostics: `auto` (on a terminal), always or never")
+)
+
+// source holds the input lines read so far, for quoting them in
+// diagnostics.
+type source struct {
+	lines []string
+}
+
+func (src *source) add(s string) {
+	src.lines = append(src.lines, s)
+}
+
+// line returns line n, counting from 1.
+func (src *source) line(n int) (string, bool) {
+	if n < 1 || n > len(src.lines) {
+		return "", false
+	}
+	return src.lines[n-1], true
+}
+
+// ANSI escape sequences for colour diagnostics.
+const (
+	ansiBold    = "\x1b[1m"
+	ansiRed     = "\x1b[1;31m"
+	ansiMagenta = "\x1b[1;35m"
+	ansiGreen   = "\x1b[1;32m"
+	ansiReset   = "\x1b[0m"
+)
+
+// diagnostics renders errors like a compiler does:
+//
+//	<stdin>:3:2: division by zero
+//		10 / i
+//		^^^^^^
+//	<stdin>:3:2: in expression statement: 10 / i
+//	<stdin>:2:1: in for loop: for i = 3; i >= 0; i--
+type diagnostics struct {
+	w     io.Writer
+	src   *source
+	caret bool
+	trace bool
+	color bool
+}
+
+func newDiagnostics(w io.Writer, src *source) *diagnostics {
+	d := &diagnostics{w: w, src: src, caret: *caretFlag, trace: *traceFlag}
+	switch *colorFlag {
+	case "always":
+		d.color = true
+	case "auto":
+		if f, ok := w.(*os.File); ok {
+			d.color = isatty.IsTerminal(f.Fd())
+		}
+	}
+	return d
+}
+
+func (d *diagnostics) paint(s, color string) string {
+	if !d.color {
+		return s
+	}
+	return color + s + ansiReset
+}
+
+// report writes err to d.w, quoting the source line if err
+// has a position, and the trace of RuntimeErrors.
+func (d *diagnostics) report(err error) {
+	var de diagError
+	if !errors.As(err, &de) {
+		fmt.Fprintln(d.w, d.paint(err.Error(), ansiRed))
+		return
+	}
+	p, e := de.span()
+	if !p.IsValid() {
+		fmt.Fprintln(d.w, d.paint(de.Error(), ansiRed))
+		return
+	}
+	color := ansiRed
+	if _, ok := de.(*Warning); ok {
+		color = ansiMagenta
+	}
+	fmt.Fprintf(d.w, "%s %s\n", d.paint(p.String()+":", ansiBold),
+		d.paint(de.message(), color))
+	if line, ok := d.src.line(p.line); d.caret && ok {
+		start, end := d.columns(line, p, e)
+		// keep the tabs so that the caret lines up with the source
+		var indent strings.Builder
+		for _, r := range line[:start] {
+			if r == '\t' {
+				indent.WriteByte('\t')
+			} else {
+				indent.WriteByte(' ')
+			}
+		}
+		n := max(utf8.RuneCountInString(line[start:end]), 1)
+		fmt.Fprintf(d.w, "%s\n%s%s\n", line, indent.String(),
+			d.paint(strings.Repeat("^", n), ansiGreen))
+	}
+	var re *RuntimeError
+	if !d.trace || !errors.As(err, &re) {
+		return
+	}
+	for _, f := range re.Trace {
+		fmt.Fprintf(d.w, "%s in %s", d.paint(f.Pos.String()+":", ansiBold), f.What)
+		if line, ok := d.src.line(f.Pos.line); ok {
+			start, end := d.columns(line, f.Pos, f.End)
+			fmt.Fprintf(d.w, ": %s", line[start:end])
+		}
+		fmt.Fprintln(d.w)
+	}
+}
+
+// columns returns the byte offsets in line of the span from pos
+// to end, which ends with the line if end is on another line.
+// Trailing white space is left out.
+func (d *diagnostics) columns(line string, pos, end pos) (int, int) {
+	i := min(pos.col-1, len(line))
+	j := len(line)
+	if end.line == pos.line && end.col > pos.col {
+		j = min(end.col-1, j)
+	}
+	j = max(i, len(strings.TrimRightFunc(line[:j], unicode.IsSpace)))
+	return i, j
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var astFlag = flag.String("ast", "",
+	"print the syntax tree of programs as `text`, json or dot "+
+		"instead of running them")
+
+// dumpFormats are the formats of -ast.
+var dumpFormats = map[string]func(io.Writer, *dumpNode) error{
+	"text": dumpText,
+	"json": dumpJSON,
+	"dot":  dumpDot,
+}
+
+// dumpNode is a node of the syntax tree as printed by -ast.
+type dumpNode struct {
+	n     node
+	kind  string // like "binary"
+	key   string // JSON key of value: "op", "name" or "value"
+	value string // operator, name or number as in the source
+	kids  []dumpKid
+}
+
+// dumpKid is a child of a dumpNode in the role it plays, like "cond".
+// The statements of blocks all have the role "stmts".
+type dumpKid struct {
+	role string
+	d    *dumpNode
+}
+
+func newDumpNode(n node) *dumpNode {
+	d := &dumpNode{n: n}
+	add := func(role string, n node) {
+		if n != nil {
+			d.kids = append(d.kids, dumpKid{role, newDumpNode(n)})
+		}
+	}
+	switch n := n.(type) {
+	case *numLit:
+		d.kind, d.key, d.value = "num", "value", n.tok.s
+	case *ident:
+		d.kind, d.key, d.value = "ident", "name", n.tok.s
+	case *unary:
+		d.kind, d.key, d.value = "unary", "op", n.op.s
+		add("x", n.x)
+	case *binary:
+		d.kind, d.key, d.value = "binary", "op", n.op.s
+		add("x", n.x)
+		add("y", n.y)
+	case *paren:
+		d.kind = "paren"
+		add("x", n.x)
+	case *assign:
+		d.kind, d.key, d.value = "assign", "op", n.op.s
+		add("name", &ident{tok: n.name})
+		add("rval", n.rval)
+	case *printStmt:
+		d.kind = "print"
+		add("x", n.x)
+	case *forStmt:
+		d.kind = "for"
+		add("init", n.init)
+		add("cond", n.cond)
+		add("post", n.post)
+		add("body", n.body)
+	case *block:
+		d.kind = "block"
+		for _, s := range n.stmts {
+			add("stmts", s)
+		}
+	}
+	return d
+}
+
+func (d *dumpNode) label(sep string) string {
+	if d.value == "" {
+		return d.kind
+	}
+	return d.kind + sep + d.value
+}
+
+// dumpAST writes the syntax tree of the program n to w in format.
+func dumpAST(w io.Writer, n node, format string) error {
+	return dumpFormats[format](w, newDumpNode(n))
+}
+
+// dumpText writes the tree like stage1 does:
+//
+//	×≡≡ top
+//	|
+//	`---+-> stmt[0]
+//	    |
+//	    `---+-> binary +
+//	        |
+//	        +-----> ident a
+//	        |
+//	        `-----> num 1
+func dumpText(w io.Writer, d *dumpNode) error {
+	var buf bytes.Buffer
+	buf.WriteString("×≡≡ top\n")
+	pref := make([]byte, 0, 64)
+	pref = append(pref, []byte("|   ")...)
+	for k, kid := range d.kids {
+		if k == len(d.kids)-1 {
+			fmt.Fprintf(&buf, "|\n`---+-> stmt[%d]\n", k)
+			pref[0] = ' '
+		} else {
+			fmt.Fprintf(&buf, "|\n+---+-> stmt[%d]\n", k)
+		}
+		kid.d.printText(&buf, pref, true)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func (d *dumpNode) printText(buf *bytes.Buffer, pref []byte, last bool) {
+	var angle, wall byte = '+', '|'
+	if last {
+		angle, wall = '`', ' '
+	}
+	fmt.Fprintf(buf, "%s|\n%s%c---", pref, pref, angle)
+	if len(d.kids) == 0 {
+		fmt.Fprintln(buf, "-->", d.label(" "))
+		return
+	}
+	fmt.Fprintln(buf, "+->", d.label(" "))
+	pref = append(pref, wall, ' ', ' ', ' ')
+	for i, kid := range d.kids {
+		kid.d.printText(buf, pref, i == len(d.kids)-1)
+	}
+}
+
+// dumpJSON writes the tree as JSON objects with the kind of node in
+// "node", its operator, name or value, its position and its children
+// by role.
+func dumpJSON(w io.Writer, d *dumpNode) error {
+	b, err := d.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b, "", "  "); err != nil {
+		return err
+	}
+	buf.WriteByte('\n')
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+func (d *dumpNode) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"node":%s`, strconv.Quote(d.kind))
+	switch n := d.n.(type) {
+	case *numLit:
+		fmt.Fprintf(&buf, `,"value":%s`, n.tok.n)
+	default:
+		if d.key != "" {
+			fmt.Fprintf(&buf, `,%s:%s`,
+				strconv.Quote(d.key), strconv.Quote(d.value))
+		}
+	}
+	start, end := d.n.Pos(), d.n.End()
+	fmt.Fprintf(&buf, `,"pos":{"line":%d,"col":%d}`, start.line, start.col)
+	fmt.Fprintf(&buf, `,"end":{"line":%d,"col":%d}`, end.line, end.col)
+	var stmts [][]byte
+	for _, kid := range d.kids {
+		b, err := kid.d.MarshalJSON()
+		if err != nil {
+			return nil, err
+		}
+		if kid.role == "stmts" {
+			stmts = append(stmts, b)
+			continue
+		}
+		fmt.Fprintf(&buf, `,%s:%s`, strconv.Quote(kid.role), b)
+	}
+	if d.kind == "block" {
+		fmt.Fprintf(&buf, `,"stmts":[%s]`, bytes.Join(stmts, []byte(",")))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// dotWriter writes a tree as a Graphviz graph in the style of the
+// slides, with the leaves in a row at the bottom.
+type dotWriter struct {
+	nodes  bytes.Buffer
+	edges  []string // in the order of the nodes
+	leaves []string
+	n      int
+}
+
+func dumpDot(w io.Writer, d *dumpNode) error {
+	var dw dotWriter
+	dw.node(d, "top")
+	var buf bytes.Buffer
+	buf.WriteString("digraph ast {\n\n        node [shape=box];\n\n")
+	buf.Write(dw.nodes.Bytes())
+	buf.WriteString("\n")
+	for _, e := range dw.edges {
+		buf.WriteString(e)
+	}
+	if len(dw.leaves) > 0 {
+		fmt.Fprintf(&buf, "\n        { rank=same; %s }\n",
+			strings.Join(dw.leaves, " "))
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// node writes the node d and its children, and returns its name.
+// If label is not empty, it is the label of d, in blue.
+func (dw *dotWriter) node(d *dumpNode, label string) string {
+	dw.n++
+	id := fmt.Sprintf("n%d", dw.n)
+	if label != "" {
+		fmt.Fprintf(&dw.nodes, "        %s [label=%q fontcolor=\"blue\"]\n",
+			id, label)
+	} else {
+		fmt.Fprintf(&dw.nodes, "        %s [label=%q]\n", id, d.label("\n"))
+	}
+	if len(d.kids) == 0 {
+		dw.leaves = append(dw.leaves, id)
+		return id
+	}
+	e := len(dw.edges)
+	dw.edges = append(dw.edges, "")
+	kids := make([]string, len(d.kids))
+	for i, kid := range d.kids {
+		kids[i] = dw.node(kid.d, "")
+	}
+	dw.edges[e] = fmt.Sprintf("        %s -> { %s }\n", id, strings.Join(kids, " "))
+	return id
+}
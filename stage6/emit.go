@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// goEmitter translates the abstract syntax tree into Go source.
+//
+// Variables become locals of the inferred types, or of type number
+// if their type is only known at run time.  Run-time errors panic
+// with runtimeError, which main recovers from and prints.
+type goEmitter struct {
+	buf     bytes.Buffer
+	types   *typeInfo
+	vars    map[string]bool // all variables
+	read    map[string]bool // variables that are read
+	checked map[string]bool // variables read while possibly undefined
+	defined map[string]bool // variables surely defined at this point
+}
+
+// emitGo writes the program n to w as a standalone Go program.
+func emitGo(w io.Writer, n node) error {
+	e := &goEmitter{
+		types:   inferTypes(n),
+		vars:    make(map[string]bool),
+		read:    make(map[string]bool),
+		checked: make(map[string]bool),
+	}
+	// the first pass finds out which variables need checks
+	for pass := 0; pass < 2; pass++ {
+		e.buf.Reset()
+		e.defined = make(map[string]bool)
+		if b, ok := n.(*block); ok {
+			e.stmts(b.stmts)
+		} else {
+			e.stmt(n)
+		}
+	}
+	names := make([]string, 0, len(e.vars))
+	for k := range e.vars {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var out bytes.Buffer
+	out.WriteString(goPrelude)
+	out.WriteString("\nfunc run() {\n")
+	for _, v := range names {
+		fmt.Fprintf(&out, "var v_%s %s\n", v, goType(e.varType(v)))
+		if e.checked[v] {
+			fmt.Fprintf(&out, "var ok_%s bool\n", v)
+		}
+		if !e.read[v] {
+			fmt.Fprintf(&out, "_ = v_%s\n", v)
+		}
+	}
+	out.Write(e.buf.Bytes())
+	out.WriteString("}\n")
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func (e *goEmitter) printf(format string, a ...interface{}) {
+	fmt.Fprintf(&e.buf, format, a...)
+}
+
+// varType returns the type of the variable s.  Variables that are
+// never set are treated as dynamic.
+func (e *goEmitter) varType(s string) numType {
+	if t := e.types.vars[s]; t != typeUnknown {
+		return t
+	}
+	return typeDynamic
+}
+
+func goType(t numType) string {
+	switch t {
+	case typeInt:
+		return "int"
+	case typeFloat:
+		return "float64"
+	}
+	return "number"
+}
+
+func goNumber(a number) string {
+	switch {
+	case !a.isFloat:
+		return strconv.Itoa(a.i)
+	case math.IsInf(a.f, 1):
+		return "math.Inf(1)"
+	case math.IsInf(a.f, -1):
+		return "math.Inf(-1)"
+	case math.IsNaN(a.f):
+		return "math.NaN()"
+	case a.f == 0 && math.Signbit(a.f):
+		return "math.Copysign(0, -1)"
+	}
+	return "float64(" + a.String() + ")"
+}
+
+// convert returns the Go expression s of type from converted to type to.
+func convert(s string, from, to numType) string {
+	switch {
+	case from == to:
+		return s
+	case to == typeInt && from == typeFloat:
+		// not int(s), which does not compile if s is a constant
+		// with a fraction
+		return "floatNum(" + s + ").Int()"
+	case to == typeInt:
+		return s + ".Int()"
+	case to == typeFloat && from == typeInt:
+		return "float64(" + s + ")"
+	case to == typeFloat:
+		return s + ".Float()"
+	case from == typeInt:
+		return "intNum(" + s + ")"
+	}
+	return "floatNum(" + s + ")"
+}
+
+// truth returns a Go boolean expression for s of type t being true.
+func truth(s string, t numType) string {
+	if t == typeDynamic {
+		return s + ".Bool()"
+	}
+	return "(" + s + " != 0)"
+}
+
+// get returns the Go expression for reading the variable n.
+func (e *goEmitter) get(n *ident) string {
+	s := n.tok.s
+	e.vars[s], e.read[s] = true, true
+	if e.defined[s] {
+		return "v_" + s
+	}
+	e.checked[s] = true
+	return fmt.Sprintf("defined(v_%s, ok_%s, %q, %q)", s, s, s, n.Pos())
+}
+
+// set writes a Go statement setting the variable s to x.
+func (e *goEmitter) set(s, x string) {
+	e.vars[s] = true
+	if e.checked[s] {
+		e.printf("v_%s, ok_%s = %s, true\n", s, s, x)
+	} else {
+		e.printf("v_%s = %s\n", s, x)
+	}
+	e.defined[s] = true
+}
+
+func (e *goEmitter) expr(n node) (string, numType) {
+	switch n := n.(type) {
+	case *numLit:
+		return goNumber(n.tok.n), typeOfNumber(n.tok.n)
+	case *ident:
+		return e.get(n), e.varType(n.tok.s)
+	case *unary:
+		x, t := e.expr(n.x)
+		switch n.op.typ {
+		case '-':
+			if t == typeDynamic {
+				return "numNeg(" + x + ")", t
+			}
+			return "-(" + x + ")", t
+		case '^':
+			return "^(" + convert(x, t, typeInt) + ")", typeInt
+		}
+		return "boolToInt(!" + truth(x, t) + ")", typeInt
+	case *paren:
+		return e.expr(n.x)
+	case *binary:
+		return e.binary(n, n.op.typ, n.x, n.y)
+	}
+	panic("expr: unknown node")
+}
+
+// assignOps maps assignment operators to binary operators.
+var assignOps = map[int]int{
+	ADDEQ:    '+',
+	SUBEQ:    '-',
+	MULEQ:    '*',
+	DIVEQ:    '/',
+	MODEQ:    '%',
+	ANDEQ:    '&',
+	XOREQ:    '^',
+	BICEQ:    BIC,
+	OREQ:     '|',
+	LSHIFTEQ: LSHIFT,
+	RSHIFTEQ: RSHIFT,
+	INC:      '+',
+	DEC:      '-',
+}
+
+var goOps = map[int]string{
+	'+': "+", '-': "-", '*': "*", '/': "/", '%': "%",
+	'&': "&", '^': "^", BIC: "&^", '|': "|", LSHIFT: "<<", RSHIFT: ">>",
+}
+
+var numFuncs = map[int]string{
+	'+': "numAdd", '-': "numSub", '*': "numMul", '/': "numDiv", '%': "numMod",
+}
+
+// binary returns the Go expression for the binary operator typ applied
+// to x and y.  Division by zero is reported at n.
+func (e *goEmitter) binary(n node, typ int, x, y node) (string, numType) {
+	a, t := e.expr(x)
+	b, u := e.expr(y)
+	switch typ {
+	case LAND, LOR:
+		r := t
+		if t != u {
+			r = typeDynamic
+		}
+		cond := truth("v", r)
+		if typ == LAND {
+			cond = "!" + cond
+		}
+		return fmt.Sprintf("func() %s { if v := %s; %s { return v }; return %s }()",
+			goType(r), convert(a, t, r), cond, convert(b, u, r)), r
+	case '&', '^', BIC, '|', LSHIFT, RSHIFT:
+		return fmt.Sprintf("(%s %s %s)", convert(a, t, typeInt), goOps[typ],
+			convert(b, u, typeInt)), typeInt
+	case EQ, NE, '<', '>', LE, GE:
+		r := binaryType('+', t, u)
+		a, b = convert(a, t, r), convert(b, u, r)
+		var s string
+		if r == typeDynamic {
+			s = map[int]string{
+				EQ: "numEqual(%s, %s)", NE: "!numEqual(%s, %s)",
+				'<': "numLess(%s, %s)", '>': "numGreater(%s, %s)",
+				LE: "!numGreater(%s, %s)", GE: "!numLess(%s, %s)",
+			}[typ]
+		} else {
+			s = map[int]string{
+				EQ: "%s == %s", NE: "!(%s == %s)",
+				'<': "%s < %s", '>': "%s > %s",
+				LE: "!(%s > %s)", GE: "!(%s < %s)",
+			}[typ]
+		}
+		return "boolToInt(" + fmt.Sprintf(s, a, b) + ")", typeInt
+	}
+	r := binaryType(typ, t, u)
+	a, b = convert(a, t, r), convert(b, u, r)
+	if typ == '/' || typ == '%' {
+		if r == typeDynamic {
+			b = fmt.Sprintf("nonZeroNum(%s, %q)", b, n.Pos())
+		} else {
+			b = fmt.Sprintf("nonZero(%s, %q)", b, n.Pos())
+		}
+	}
+	switch {
+	case r == typeDynamic:
+		return fmt.Sprintf("%s(%s, %s)", numFuncs[typ], a, b), r
+	case typ == '%' && r == typeFloat:
+		return fmt.Sprintf("math.Mod(%s, %s)", a, b), r
+	}
+	return fmt.Sprintf("(%s %s %s)", a, goOps[typ], b), r
+}
+
+func (e *goEmitter) stmt(n node) {
+	switch n := n.(type) {
+	case *assign:
+		var (
+			x string
+			t numType
+		)
+		switch {
+		case n.op.op == nil:
+			x, t = e.expr(n.rval)
+		case n.rval == nil:
+			one := &numLit{tok: token{typ: NUM, n: number{i: 1}}}
+			x, t = e.binary(n, assignOps[n.op.typ], &ident{tok: n.name}, one)
+		default:
+			x, t = e.binary(n, assignOps[n.op.typ], &ident{tok: n.name}, n.rval)
+		}
+		e.set(n.name.s, convert(x, t, e.varType(n.name.s)))
+	case *printStmt:
+		x, t := e.expr(n.x)
+		if t == typeFloat {
+			x = "strconv.FormatFloat(" + x + ", 'g', -1, 64)"
+		}
+		e.printf("fmt.Println(%s)\n", x)
+	case *forStmt:
+		if n.init != nil {
+			e.stmt(n.init)
+		}
+		defined := make(map[string]bool, len(e.defined))
+		for k, v := range e.defined {
+			defined[k] = v
+		}
+		x, t := e.expr(n.cond)
+		e.printf("for %s {\n", truth(x, t))
+		e.stmts(n.body.stmts)
+		if n.post != nil {
+			e.stmt(n.post)
+		}
+		e.printf("}\n")
+		// the body may never run
+		e.defined = defined
+	case *block:
+		e.printf("{\n")
+		e.stmts(n.stmts)
+		e.printf("}\n")
+	case *command:
+	default:
+		panic("stmt: unknown node")
+	}
+}
+
+func (e *goEmitter) stmts(l []node) {
+	for _, v := range l {
+		e.stmt(v)
+	}
+}
+
+// goHeader is written separately from goPrelude so that none of its lines
+// look like a package or import clause to the install target.
+const goHeader = "// Code generated by stage6 -emit=go. DO NOT EDIT.\n\n" +
+	"package main\n\n" +
+	"import (\n\t\"errors\"\n\t\"fmt\"\n\t\"math\"\n\t\"os\"\n\t\"strconv\"\n)\n\n"
+
+// goPrelude is the beginning of every program emitted by emitGo.
+var goPrelude = goHeader + `
+type runtimeError struct {
+	error
+}
+
+var errZeroDivision = errors.New("division by zero")
+
+func fail(pos string, err error) {
+	panic(runtimeError{fmt.Errorf("%s: %w", pos, err)})
+}
+
+// number is a value whose type is only known at run time.
+type number struct {
+	i       int
+	f       float64
+	isFloat bool
+}
+
+func intNum(i int) number {
+	return number{i: i}
+}
+
+func floatNum(f float64) number {
+	return number{f: f, isFloat: true}
+}
+
+func (a number) Bool() bool {
+	if a.isFloat {
+		return a.f != 0
+	}
+	return a.i != 0
+}
+
+func (a number) Int() int {
+	if a.isFloat {
+		return int(a.f)
+	}
+	return a.i
+}
+
+func (a number) Float() float64 {
+	if a.isFloat {
+		return a.f
+	}
+	return float64(a.i)
+}
+
+func (a number) String() string {
+	if a.isFloat {
+		return strconv.FormatFloat(a.f, 'g', -1, 64)
+	}
+	return strconv.FormatInt(int64(a.i), 10)
+}
+
+// arith applies fi to integers, or ff if either a or b is floating point.
+func arith(a, b number, fi func(a, b int) int, ff func(a, b float64) float64) number {
+	if a.isFloat || b.isFloat {
+		return floatNum(ff(a.Float(), b.Float()))
+	}
+	return intNum(fi(a.i, b.i))
+}
+
+func numAdd(a, b number) number {
+	return arith(a, b,
+		func(a, b int) int { return a + b },
+		func(a, b float64) float64 { return a + b })
+}
+
+func numSub(a, b number) number {
+	return arith(a, b,
+		func(a, b int) int { return a - b },
+		func(a, b float64) float64 { return a - b })
+}
+
+func numMul(a, b number) number {
+	return arith(a, b,
+		func(a, b int) int { return a * b },
+		func(a, b float64) float64 { return a * b })
+}
+
+func numDiv(a, b number) number {
+	return arith(a, b,
+		func(a, b int) int { return a / b },
+		func(a, b float64) float64 { return a / b })
+}
+
+func numMod(a, b number) number {
+	return arith(a, b,
+		func(a, b int) int { return a % b },
+		math.Mod)
+}
+
+func numNeg(a number) number {
+	if a.isFloat {
+		return floatNum(-a.f)
+	}
+	return intNum(-a.i)
+}
+
+func numEqual(a, b number) bool {
+	if a.isFloat || b.isFloat {
+		return a.Float() == b.Float()
+	}
+	return a.i == b.i
+}
+
+func numLess(a, b number) bool {
+	if a.isFloat || b.isFloat {
+		return a.Float() < b.Float()
+	}
+	return a.i < b.i
+}
+
+func numGreater(a, b number) bool {
+	return numLess(b, a)
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func nonZero[T int | float64](a T, pos string) T {
+	if a == 0 {
+		fail(pos, errZeroDivision)
+	}
+	return a
+}
+
+func nonZeroNum(a number, pos string) number {
+	if !a.Bool() {
+		fail(pos, errZeroDivision)
+	}
+	return a
+}
+
+func defined[T int | float64 | number](a T, ok bool, name, pos string) T {
+	if !ok {
+		fail(pos, fmt.Errorf("unknown variable %s", name))
+	}
+	return a
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(runtimeError)
+			if !ok {
+				panic(r)
+			}
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}()
+	run()
+}
+`
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// pos is a position in the input.  Lines and columns start at 1,
+// columns count bytes.  The zero pos is unknown.
+type pos struct {
+	file      string
+	line, col int
+}
+
+func (p pos) IsValid() bool {
+	return p.line > 0
+}
+
+func (p pos) String() string {
+	if !p.IsValid() {
+		return p.file
+	}
+	return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.col)
+}
+
+// diagError is an error in the input between two positions, which
+// diagnostics quote the source of.
+type diagError interface {
+	error
+	span() (pos, pos)
+	message() string // the error without the position
+}
+
+func errorString(e diagError) string {
+	start, _ := e.span()
+	return start.String() + ": " + e.message()
+}
+
+// LexError is an invalid token.
+type LexError struct {
+	Pos, End pos
+	Text     string // the token
+	Err      error  // why it's invalid, if not an invalid character
+}
+
+func (e *LexError) span() (pos, pos) { return e.Pos, e.End }
+func (e *LexError) Error() string    { return errorString(e) }
+func (e *LexError) Unwrap() error    { return e.Err }
+
+func (e *LexError) message() string {
+	if e.Err != nil {
+		return "invalid number " + e.Text
+	}
+	return fmt.Sprintf("invalid character %q", e.Text)
+}
+
+// SyntaxError is an error reported by the parser.
+type SyntaxError struct {
+	Pos, End   pos
+	Msg        string   // what's wrong
+	Unexpected string   // the token found, as described by token.describe
+	Expected   []string // tokens that could be there, if few
+	Suggestion string   // the keyword a misspelt identifier could be
+}
+
+func (e *SyntaxError) span() (pos, pos) { return e.Pos, e.End }
+func (e *SyntaxError) Error() string    { return errorString(e) }
+
+func (e *SyntaxError) message() string {
+	if e.Msg == "" {
+		return "syntax error"
+	}
+	s := "syntax error: " + e.Msg
+	if len(e.Expected) > 0 {
+		s += ", expecting " + strings.Join(e.Expected, " or ")
+	}
+	if e.Suggestion != "" {
+		s += "; did you mean " + e.Suggestion + "?"
+	}
+	return s
+}
+
+// RuntimeError is an error running a program, at the construct
+// that failed.  The trace lists the constructs enclosing it,
+// innermost first.
+type RuntimeError struct {
+	Pos, End pos
+	Err      error
+	Trace    []Frame
+}
+
+// Frame is a construct enclosing a runtime error, like a for loop.
+type Frame struct {
+	What     string
+	Pos, End pos
+}
+
+func (e *RuntimeError) span() (pos, pos) { return e.Pos, e.End }
+func (e *RuntimeError) message() string  { return e.Err.Error() }
+func (e *RuntimeError) Error() string    { return errorString(e) }
+func (e *RuntimeError) Unwrap() error    { return e.Err }
+
+// UnknownVariableError is a reference to a variable that has
+// never been set.  It is wrapped in a RuntimeError.
+type UnknownVariableError struct {
+	Pos, End   pos
+	Name       string
+	Suggestion string // a similar variable or keyword, if any
+}
+
+func (e *UnknownVariableError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("unknown variable %s; did you mean %s?",
+			e.Name, e.Suggestion)
+	}
+	return "unknown variable " + e.Name
+}
+
+// LimitError is a resource limit a program went over, with -gas,
+// -loops, -vars, -depth or -output.  It is wrapped in a RuntimeError,
+// and errors.Is reports it as ErrLimitExceeded.
+type LimitError struct {
+	Name string // what is limited, like "loop depth"
+	Max  int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v: %s (max %d)", ErrLimitExceeded, e.Name, e.Max)
+}
+
+func (e *LimitError) Unwrap() error { return ErrLimitExceeded }
+
+// Warning is a likely mistake in a program, found by -vet.
+type Warning struct {
+	Pos, End pos
+	Msg      string
+}
+
+func (w *Warning) span() (pos, pos) { return w.Pos, w.End }
+func (w *Warning) message() string  { return "warning: " + w.Msg }
+func (w *Warning) Error() string    { return errorString(w) }
+
+// withPos returns err as a RuntimeError at the position of n.
+// If err already is one, n is added to its trace instead.
+func withPos(n node, err error) error {
+	if n == nil {
+		return err
+	}
+	start, end := span(n)
+	var re *RuntimeError
+	if errors.As(err, &re) {
+		re.Trace = append(re.Trace, Frame{What: what(n), Pos: start, End: end})
+		return err
+	}
+	var uv *UnknownVariableError
+	if errors.As(err, &uv) {
+		uv.Pos, uv.End = start, end
+	}
+	return &RuntimeError{Pos: start, End: end, Err: err}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"math"
+	"strings"
+)
+
+var fmtFlag = flag.Bool("fmt", false,
+	"print the input in the canonical format instead of running it")
+
+// formatter prints a program in the canonical format: one statement
+// per line, blocks indented with tabs, spaces around binary and
+// assignment operators and no more parentheses than needed.
+// Comments stay before or after the statements they are next to in
+// the source, and blank lines between statements are kept, but not
+// more than one.
+type formatter struct {
+	buf      strings.Builder
+	comments []token // comments not printed yet
+	indent   int
+	line     int // source line of the last output line, 0 at block start
+}
+
+// formatSource writes the program n with comments to w.
+func formatSource(w io.Writer, n *block, comments []token) error {
+	f := &formatter{comments: comments}
+	f.stmts(n.stmts, pos{})
+	f.leading(math.MaxInt)
+	_, err := io.WriteString(w, f.buf.String())
+	return err
+}
+
+// start starts an output line for the source line, after a blank line
+// if the source has any since the last line printed.
+func (f *formatter) start(line int) {
+	if f.line > 0 && line > f.line+1 {
+		f.buf.WriteByte('\n')
+	}
+	f.buf.WriteString(strings.Repeat("\t", f.indent))
+}
+
+// newline ends the output line, which ends the source line line,
+// with the comment at the end of the source line, unless the source
+// line goes on with the next thing printed, on the source line next.
+func (f *formatter) newline(line, next int) {
+	if c := f.comments; line != next && len(c) > 0 && c[0].pos.line == line {
+		f.buf.WriteString(" " + c[0].s)
+		f.comments = c[1:]
+	}
+	f.buf.WriteByte('\n')
+	f.line = line
+}
+
+// leading prints the comments before the source line, each on a line
+// of its own.
+func (f *formatter) leading(line int) {
+	for len(f.comments) > 0 && f.comments[0].pos.line < line {
+		c := f.comments[0]
+		f.comments = f.comments[1:]
+		f.start(c.pos.line)
+		f.buf.WriteString(c.s + "\n")
+		f.line = c.pos.line
+	}
+}
+
+// stmts prints the statements, which are followed in the source by
+// something at next.
+func (f *formatter) stmts(l []node, next pos) {
+	for i, n := range l {
+		f.leading(n.Pos().line)
+		f.start(n.Pos().line)
+		f.stmt(n)
+		if i+1 < len(l) {
+			f.newline(n.End().line, l[i+1].Pos().line)
+		} else {
+			f.newline(n.End().line, next.line)
+		}
+	}
+}
+
+func (f *formatter) stmt(n node) {
+	switch n := n.(type) {
+	case *forStmt:
+		f.buf.WriteString("for ")
+		if n.init != nil {
+			f.buf.WriteString(simpleStmt(n.init) + "; ")
+		}
+		f.buf.WriteString(formatExpr(n.cond, 0))
+		if n.post != nil {
+			f.buf.WriteString("; " + simpleStmt(n.post))
+		}
+		f.buf.WriteByte(' ')
+		f.block(n.body)
+	case *block:
+		f.block(n)
+	default:
+		f.buf.WriteString(simpleStmt(n))
+	}
+}
+
+func (f *formatter) block(b *block) {
+	next := b.rbrace
+	if len(b.stmts) > 0 {
+		next = b.stmts[0].Pos()
+	}
+	f.buf.WriteByte('{')
+	f.newline(b.lbrace.line, next.line)
+	f.indent++
+	f.line = 0 // no blank line after '{'
+	f.stmts(b.stmts, b.rbrace)
+	f.leading(b.rbrace.line)
+	f.indent--
+	f.buf.WriteString(strings.Repeat("\t", f.indent) + "}")
+}
+
+// simpleStmt returns an assignment or expression statement formatted.
+func simpleStmt(n node) string {
+	switch n := n.(type) {
+	case *assign:
+		if n.rval == nil {
+			return n.name.s + n.op.s
+		}
+		return n.name.s + " " + n.op.s + " " + formatExpr(n.rval, 0)
+	case *printStmt:
+		return formatExpr(n.x, 0)
+	}
+	panic("not a simple statement")
+}
+
+// Precedence of unary operators, which bind tighter than the binary
+// operators.
+const unaryPrec = 6
+
+// formatExpr returns n formatted, in parentheses if its precedence
+// is lower than prec.
+func formatExpr(n node, prec int) string {
+	switch n := n.(type) {
+	case *numLit:
+		return n.tok.s
+	case *ident:
+		return n.tok.s
+	case *paren:
+		return formatExpr(n.x, prec)
+	case *unary:
+		x := formatExpr(n.x, unaryPrec)
+		if n.op.s == "-" && strings.HasPrefix(x, "-") {
+			// "--" is a token of its own
+			return "- " + x
+		}
+		return n.op.s + x
+	case *binary:
+		p := precedence(n.op.typ)
+		s := formatExpr(n.x, p) + " " + n.op.s + " " + formatExpr(n.y, p+1)
+		if p < prec {
+			return "(" + s + ")"
+		}
+		return s
+	}
+	panic("not an expression")
+}
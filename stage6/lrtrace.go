@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+)
+
+var (
+	lrTraceFlag = flag.String("lrtrace", "",
+		"trace the shifts, reductions and gotos of the parser as `text` "+
+			"or as dot files, one per step")
+	lrDirFlag = flag.String("lrdir", ".",
+		"write the dot files of -lrtrace=dot to `dir`")
+	yyDebugFlag = flag.Int("yydebug", 0,
+		"print goyacc's debugging output at `level` 1 to 4")
+)
+
+// lrTracer follows the moves of the parser by running the goyacc
+// tables on the tokens the parser reads, the way yyParse does.
+// The parser has no hooks of its own, and its yyDebug output
+// doesn't show the stack.  So the trace is a simulation of the
+// parser, which relies on the layout of the goyacc tables, and
+// make check compares the states it pushes with those yyDebug shows.
+//
+// The text trace is the classic table of the stack, the lookahead
+// and the action:
+//
+//	0 stmts 2 IDENT 14 '=' 37   NUM   reduce assignop: '='
+//
+// The DOT frames draw the parse trees on the stack like the slides,
+// with the symbols a reduction pops in red and the one a shift or
+// goto pushes in blue.
+//
+// After a syntax error, the trace stops until the next program, as
+// error recovery depends on the actions of the grammar.
+type lrTracer struct {
+	dot   bool
+	stack []lrEntry
+	done  bool // the program is accepted or has a syntax error
+	step  int  // number of the last DOT frame
+	tw    *tabwriter.Writer
+	err   error
+}
+
+// lrEntry is a parser stack entry.
+type lrEntry struct {
+	state int
+	tree  *lrTree
+}
+
+// lrTree is the parse tree under a stack entry: a grammar symbol
+// with the symbols it was reduced from, or a token.
+type lrTree struct {
+	sym  string
+	tok  *token
+	kids []*lrTree
+}
+
+func newLRTracer(format string) *lrTracer {
+	return &lrTracer{
+		dot: format == "dot",
+		tw:  tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0),
+	}
+}
+
+// start starts tracing a new program.
+func (t *lrTracer) start() {
+	t.stack = append(t.stack[:0], lrEntry{})
+	t.done = false
+	if !t.dot {
+		fmt.Fprintln(t.tw, "stack\tlookahead\taction")
+	}
+}
+
+// end ends tracing a program.
+func (t *lrTracer) end() error {
+	if err := t.tw.Flush(); err != nil && t.err == nil {
+		t.err = err
+	}
+	err := t.err
+	t.err = nil
+	return err
+}
+
+// token runs the parser tables on tok until it's shifted, or the
+// program is accepted or has a syntax error.
+func (t *lrTracer) token(tok token) {
+	if t.done {
+		return
+	}
+	la := yyToken(tok.typ)
+	for {
+		state := t.stack[len(t.stack)-1].state
+		n := int(yyPact[state]) + la
+		if int(yyPact[state]) > yyFlag && n >= 0 && n < yyLast {
+			if s := int(yyAct[n]); int(yyChk[s]) == la {
+				t.show(fmt.Sprintf("shift %d", s), &tok)
+				t.stack = append(t.stack, lrEntry{s, &lrTree{tok: &tok}})
+				t.frame(fmt.Sprintf("shift %d", s), nil, 0, 1)
+				return
+			}
+		}
+		n = int(yyDef[state])
+		if n == -2 {
+			// look through the exception table
+			xi := 0
+			for yyExca[xi] != -1 || int(yyExca[xi+1]) != state {
+				xi += 2
+			}
+			for xi += 2; ; xi += 2 {
+				if n = int(yyExca[xi]); n < 0 || n == la {
+					break
+				}
+			}
+			if n = int(yyExca[xi+1]); n < 0 {
+				t.show("accept", &tok)
+				t.frame("accept", &tok, 0, 0)
+				t.done = true
+				return
+			}
+		}
+		if n == 0 {
+			t.show("error", &tok)
+			t.frame("error", &tok, 0, 0)
+			t.done = true
+			return
+		}
+		t.reduce(n, &tok)
+	}
+}
+
+// reduce reduces by the rule n, and goes to the state for its left
+// side.
+func (t *lrTracer) reduce(n int, la *token) {
+	rule := yyRules[n]
+	k := int(yyR2[n])
+	t.show("reduce "+rule, la)
+	t.frame("reduce "+rule, la, k, 0)
+	lhs := &lrTree{sym: rule[:strings.IndexByte(rule, ':')]}
+	for _, e := range t.stack[len(t.stack)-k:] {
+		lhs.kids = append(lhs.kids, e.tree)
+	}
+	t.stack = t.stack[:len(t.stack)-k]
+
+	// consult the goto table for the next state
+	nt := int(yyR1[n])
+	g := int(yyPgo[nt])
+	state := int(yyAct[g])
+	if j := g + t.stack[len(t.stack)-1].state + 1; j < yyLast {
+		if s := int(yyAct[j]); int(yyChk[s]) == -nt {
+			state = s
+		}
+	}
+	t.stack = append(t.stack, lrEntry{-1, lhs})
+	t.show(fmt.Sprintf("goto %d", state), la)
+	t.stack[len(t.stack)-1].state = state
+	t.frame(fmt.Sprintf("goto %d", state), la, 0, 1)
+}
+
+func (tr *lrTree) name() string {
+	if tr.tok != nil {
+		return tokenName(tr.tok.typ)
+	}
+	return tr.sym
+}
+
+// show writes a line of the text trace, before the action on the
+// lookahead la.  The state of the top of the stack is -1 while
+// the parser consults the goto table.
+func (t *lrTracer) show(action string, la *token) {
+	if t.dot {
+		return
+	}
+	var stack strings.Builder
+	for i, e := range t.stack {
+		if i > 0 {
+			stack.WriteString(e.tree.name() + " ")
+		}
+		if e.state >= 0 {
+			fmt.Fprintf(&stack, "%d ", e.state)
+		}
+	}
+	fmt.Fprintf(t.tw, "%s\t%s\t%s\n",
+		stack.String(), tokenName(la.typ), action)
+}
+
+// frame writes a DOT file showing the stack after a step, with the
+// top pop trees in red, the top push trees in blue and the lookahead
+// token, if any, in a dashed box.  The tokens are in a row at the
+// bottom.
+func (t *lrTracer) frame(action string, la *token, pop, push int) {
+	if !t.dot || t.err != nil {
+		return
+	}
+	t.step++
+	var (
+		buf    bytes.Buffer
+		leaves []string
+		n      int
+	)
+	var walk func(tr *lrTree, attrs string) string
+	walk = func(tr *lrTree, attrs string) string {
+		n++
+		id := fmt.Sprintf("n%d", n)
+		label := "<" + tr.name() + ">"
+		if tr.tok != nil && tr.tok.typ != NUM && tr.tok.typ != IDENT {
+			label = lrTokenText(tr.tok)
+		}
+		fmt.Fprintf(&buf, "        %s [label=%q%s]\n", id, label, attrs)
+		if tr.tok != nil && (tr.tok.typ == NUM || tr.tok.typ == IDENT) {
+			n++
+			leaf := fmt.Sprintf("n%d", n)
+			fmt.Fprintf(&buf, "        %s [label=%q]\n", leaf, tr.tok.s)
+			fmt.Fprintf(&buf, "        %s -> %s\n", id, leaf)
+			leaves = append(leaves, leaf)
+		} else if tr.tok != nil {
+			leaves = append(leaves, id)
+		}
+		for _, kid := range tr.kids {
+			fmt.Fprintf(&buf, "        %s -> %s\n", id, walk(kid, ""))
+		}
+		return id
+	}
+	fmt.Fprintf(&buf, "digraph lr {\n\n        label=%q\n", action)
+	buf.WriteString("        ordering=out\n        node [shape=box];\n\n")
+	top := len(t.stack) - 1
+	for i, e := range t.stack[1:] {
+		attrs := ""
+		switch {
+		case i+1 > top-pop:
+			attrs = ` color="red"`
+		case i+1 > top-push:
+			attrs = ` fontcolor="blue"`
+		}
+		walk(e.tree, attrs)
+	}
+	if la != nil {
+		n++
+		id := fmt.Sprintf("n%d", n)
+		fmt.Fprintf(&buf, "        %s [label=%q style=\"dashed\"]\n",
+			id, lrTokenText(la))
+		leaves = append(leaves, id)
+	}
+	if len(leaves) > 0 {
+		fmt.Fprintf(&buf, "\n        { rank=same; %s }\n",
+			strings.Join(leaves, " "))
+	}
+	buf.WriteString("}\n")
+	name := filepath.Join(*lrDirFlag, fmt.Sprintf("lr%04d.dot", t.step))
+	t.err = os.WriteFile(name, buf.Bytes(), 0666)
+}
+
+// lrTokenText returns the text of tok in a DOT frame.
+func lrTokenText(tok *token) string {
+	if tok.s == "" {
+		return tokenName(tok.typ)
+	}
+	return tok.s
+}
+
+// yyToken returns the number of the token type typ in the parser
+// tables, as yylex1 translates it.
+func yyToken(typ int) int {
+	switch {
+	case typ <= 0:
+		return int(yyTok1[0])
+	case typ < len(yyTok1):
+		return int(yyTok1[typ])
+	case typ >= yyPrivate && typ < yyPrivate+len(yyTok2):
+		return int(yyTok2[typ-yyPrivate])
+	}
+	return int(yyTok2[1]) // unknown
+}
@@ -1,4 +1,5 @@
-//go:generate goyacc parse.y
+//go:generate sh -c "goyacc -o y.go.tmp parse.y && grep -v '^const [A-Z][A-Z]* = [0-9]*$DOLLAR' y.go.tmp | gofmt > y.go && rm y.go.tmp"
+//go:generate sh -c "awk -f rules.awk y.output > yrules.go"
 
 package main
 
@@ -6,18 +7,41 @@ import (
 	"bufio"
 	"bytes"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"math"
 	"os"
 	"strconv"
 	"strings"
+	"time"
+	"unicode"
 
 	"github.com/mattn/go-isatty"
 )
 
 var ErrZeroDivision = errors.New("division by zero")
 
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// limit is a resource limit for running untrusted scripts.
+// If max is 0, the resource is not limited.
+type limit struct {
+	name string
+	max  int
+	n    int
+}
+
+// add counts n more of the resource, unless that would exceed the
+// limit, in which case it counts nothing and returns an error.
+func (l *limit) add(n int) error {
+	if l.max > 0 && l.n+n > l.max {
+		return &LimitError{Name: l.name, Max: l.max}
+	}
+	l.n += n
+	return nil
+}
+
 type number struct {
 	i       int
 	f       float64
@@ -81,6 +105,13 @@ type op interface {
 	NewFun(fun, fun) fun
 }
 
+// typedOp is an operator that can make closures specialised for
+// operands of the same statically known type, typeInt or typeFloat.
+type typedOp interface {
+	op
+	NewTypedFun(t numType, left, right fun) fun
+}
+
 type (
 	unOp        func(number) number
 	binOp       func(number, number) number
@@ -119,6 +150,9 @@ func newUnOp(uif unIntFun, uff unFloatFun) unOp {
 
 func (f binOp) NewFun(left, right fun) fun {
 	return func() (number, error) {
+		if err := runtime.limits.gas.add(1); err != nil {
+			return number{}, err
+		}
 		a, err := left()
 		if err != nil {
 			return number{}, err
@@ -131,9 +165,76 @@ func (f binOp) NewFun(left, right fun) fun {
 	}
 }
 
-func newBinIntOp(f binIntFun) binOp {
-	return func(a, b number) number {
-		return number{i: f(a.Int(), b.Int())}
+// arithOp is a binary operator built from integer and floating
+// point functions.  Integer-only operators have no floating point
+// function.
+type arithOp struct {
+	binOp
+	bif binIntFun
+	bff binFloatFun
+}
+
+func (f arithOp) NewTypedFun(t numType, left, right fun) fun {
+	return f.newTypedFun(t, left, right, false)
+}
+
+// newTypedFun makes a closure like binOp.NewFun does, which calls bif
+// or bff on the operands of type t itself, saving the calls through
+// binOp and castToSame.  If denom is set, a zero right operand is an
+// error, as with fun.Denominator.
+func (f arithOp) newTypedFun(t numType, left, right fun, denom bool) fun {
+	switch {
+	case t == typeInt:
+		bif := f.bif
+		return func() (number, error) {
+			if err := runtime.limits.gas.add(1); err != nil {
+				return number{}, err
+			}
+			a, err := left()
+			if err != nil {
+				return number{}, err
+			}
+			b, err := right()
+			if err != nil {
+				return number{}, err
+			}
+			if denom && b.i == 0 {
+				return number{}, ErrZeroDivision
+			}
+			return number{i: bif(a.i, b.i)}, nil
+		}
+	case t == typeFloat && f.bff != nil:
+		bff := f.bff
+		return func() (number, error) {
+			if err := runtime.limits.gas.add(1); err != nil {
+				return number{}, err
+			}
+			a, err := left()
+			if err != nil {
+				return number{}, err
+			}
+			b, err := right()
+			if err != nil {
+				return number{}, err
+			}
+			if denom && b.f == 0 {
+				return number{}, ErrZeroDivision
+			}
+			return number{f: bff(a.f, b.f), isFloat: true}, nil
+		}
+	}
+	if denom {
+		right = right.Denominator()
+	}
+	return f.NewFun(left, right)
+}
+
+func newBinIntOp(f binIntFun) arithOp {
+	return arithOp{
+		binOp: func(a, b number) number {
+			return number{i: f(a.Int(), b.Int())}
+		},
+		bif: f,
 	}
 }
 
@@ -150,25 +251,33 @@ func castToSame(f binOp) binOp {
 	}
 }
 
-func newBinOp(bif binIntFun, bff binFloatFun) binOp {
-	return castToSame(func(a, b number) number {
-		if a.isFloat {
-			a.f = bff(a.f, b.f)
-		} else {
-			a.i = bif(a.i, b.i)
-		}
-		return a
-	})
+func newBinOp(bif binIntFun, bff binFloatFun) arithOp {
+	return arithOp{
+		binOp: castToSame(func(a, b number) number {
+			if a.isFloat {
+				a.f = bff(a.f, b.f)
+			} else {
+				a.i = bif(a.i, b.i)
+			}
+			return a
+		}),
+		bif: bif,
+		bff: bff,
+	}
 }
 
-type divModOp binOp
+type divModOp arithOp
 
 func newDivModOp(bif binIntFun, bff binFloatFun) divModOp {
 	return divModOp(newBinOp(bif, bff))
 }
 
 func (f divModOp) NewFun(left, right fun) fun {
-	return binOp(f).NewFun(left, right.Denominator())
+	return arithOp(f).NewFun(left, right.Denominator())
+}
+
+func (f divModOp) NewTypedFun(t numType, left, right fun) fun {
+	return arithOp(f).newTypedFun(t, left, right, true)
 }
 
 type multiOp struct {
@@ -182,6 +291,13 @@ func (f multiOp) NewFun(left, right fun) fun {
 	return f.bin.NewFun(left, right)
 }
 
+func (f multiOp) NewTypedFun(t numType, left, right fun) fun {
+	if bin, ok := f.bin.(typedOp); ok && right != nil {
+		return bin.NewTypedFun(t, left, right)
+	}
+	return f.NewFun(left, right)
+}
+
 var (
 	equalOp = castToSame(func(a, b number) number {
 		if a.isFloat {
@@ -200,6 +316,22 @@ var (
 	}
 )
 
+// compareOps are comparison functions for operands of a known type.
+type compareOps struct {
+	equal, less binOp
+}
+
+var typedCompareOps = map[numType]compareOps{
+	typeInt: {
+		equal: func(a, b number) number { return boolToNumber(a.i == b.i) },
+		less:  func(a, b number) number { return boolToNumber(a.i < b.i) },
+	},
+	typeFloat: {
+		equal: func(a, b number) number { return boolToNumber(a.f == b.f) },
+		less:  func(a, b number) number { return boolToNumber(a.f < b.f) },
+	},
+}
+
 type compareOp uint8
 
 const (
@@ -209,6 +341,10 @@ const (
 )
 
 func (f compareOp) BinOp() binOp {
+	return f.newBinOp(equalOp, lessOp, greaterOp)
+}
+
+func (f compareOp) newBinOp(equal, less, greater binOp) binOp {
 	var bf binOp
 	not := (f & (f - 1)) != 0
 	if not {
@@ -216,11 +352,11 @@ func (f compareOp) BinOp() binOp {
 	}
 	switch f {
 	case Equal:
-		bf = equalOp
+		bf = equal
 	case Less:
-		bf = lessOp
+		bf = less
 	case Greater:
-		bf = greaterOp
+		bf = greater
 	}
 	if not {
 		return func(a, b number) number {
@@ -236,6 +372,15 @@ func (f compareOp) NewFun(left, right fun) fun {
 	return f.BinOp().NewFun(left, right)
 }
 
+func (f compareOp) NewTypedFun(t numType, left, right fun) fun {
+	ops, ok := typedCompareOps[t]
+	if !ok {
+		return f.NewFun(left, right)
+	}
+	greater := func(a, b number) number { return ops.less(b, a) }
+	return f.newBinOp(ops.equal, ops.less, greater).NewFun(left, right)
+}
+
 type logicOp bool
 
 const (
@@ -256,10 +401,18 @@ type forLoop struct{}
 
 func (forLoop) NewFun(expr, block fun) fun {
 	return func() (number, error) {
+		depth := &runtime.limits.depth
+		if err := depth.add(1); err != nil {
+			return number{}, err
+		}
+		defer depth.add(-1)
 		for {
 			if v, err := expr(); err != nil || !v.Bool() {
 				return number{}, err
 			}
+			if err := runtime.limits.loops.add(1); err != nil {
+				return number{}, err
+			}
 			if _, err := block(); err != nil {
 				return number{}, err
 			}
@@ -325,12 +478,40 @@ var (
 		func(a float64) float64 { return a - 1 },
 	)
 	notOp   unOp = func(a number) number { return boolToNumber(!a.Bool()) }
-	printOp unOp = func(a number) number { fmt.Println(a); return a }
+	printOp printer
 )
 
+type printer struct{}
+
+func (printer) NewFun(left, right fun) fun {
+	return func() (number, error) {
+		a, err := left()
+		if err != nil {
+			return number{}, err
+		}
+		if err := printNumber(a); err != nil {
+			return number{}, err
+		}
+		return a, nil
+	}
+}
+
+func printNumber(a number) error {
+	s := a.String() + "\n"
+	if err := runtime.limits.output.add(len(s)); err != nil {
+		return err
+	}
+	fmt.Print(s)
+	return nil
+}
+
+// opMap maps operators to their token types, their functions and their
+// precedence as binary operators: 1 for "||" to 5 for "*" and the like,
+// as in the expr to expr5 rules of the grammar, and 0 for the others.
 type opMap map[string]struct {
-	typ int
-	op  op
+	typ  int
+	op   op
+	prec int
 }
 
 func (m opMap) find(s string) (token, int) {
@@ -347,40 +528,52 @@ func (m opMap) find(s string) (token, int) {
 	return token{typ: int(s[0])}, 1
 }
 
+// precedences returns the precedences of the binary operators in m
+// by token type.
+func (m opMap) precedences() map[int]int {
+	p := make(map[int]int)
+	for _, o := range m {
+		if o.prec > 0 {
+			p[o.typ] = o.prec
+		}
+	}
+	return p
+}
+
 var ops = opMap{
-	"+":   {'+', addOp},
-	"-":   {'-', subOp},
-	"*":   {'*', mulOp},
-	"/":   {'/', divOp},
-	"%":   {'%', modOp},
-	"&":   {'&', andOp},
-	"^":   {'^', xorOp},
-	"&^":  {BIC, bicOp},
-	"|":   {'|', orOp},
-	"<<":  {LSHIFT, lShiftOp},
-	">>":  {RSHIFT, rShiftOp},
-	"!":   {'!', notOp},
-	"<":   {'<', Less},
-	">":   {'>', Greater},
-	"<=":  {LE, Less | Equal},
-	">=":  {GE, Greater | Equal},
-	"==":  {EQ, Equal},
-	"!=":  {NE, Less | Greater},
-	"&&":  {LAND, logicalAnd},
-	"||":  {LOR, logicalOr},
-	"+=":  {ADDEQ, addOp},
-	"-=":  {SUBEQ, subOp},
-	"*=":  {MULEQ, mulOp},
-	"/=":  {DIVEQ, divOp},
-	"%=":  {MODEQ, modOp},
-	"&=":  {ANDEQ, andOp},
-	"^=":  {XOREQ, xorOp},
-	"&^=": {BICEQ, bicOp},
-	"|=":  {OREQ, orOp},
-	"<<=": {LSHIFTEQ, lShiftOp},
-	">>=": {RSHIFTEQ, rShiftOp},
-	"++":  {INC, incOp},
-	"--":  {DEC, decOp},
+	"+":   {'+', addOp, 4},
+	"-":   {'-', subOp, 4},
+	"*":   {'*', mulOp, 5},
+	"/":   {'/', divOp, 5},
+	"%":   {'%', modOp, 5},
+	"&":   {'&', andOp, 5},
+	"^":   {'^', xorOp, 4},
+	"&^":  {BIC, bicOp, 5},
+	"|":   {'|', orOp, 4},
+	"<<":  {LSHIFT, lShiftOp, 5},
+	">>":  {RSHIFT, rShiftOp, 5},
+	"!":   {'!', notOp, 0},
+	"<":   {'<', Less, 3},
+	">":   {'>', Greater, 3},
+	"<=":  {LE, Less | Equal, 3},
+	">=":  {GE, Greater | Equal, 3},
+	"==":  {EQ, Equal, 3},
+	"!=":  {NE, Less | Greater, 3},
+	"&&":  {LAND, logicalAnd, 2},
+	"||":  {LOR, logicalOr, 1},
+	"+=":  {ADDEQ, addOp, 0},
+	"-=":  {SUBEQ, subOp, 0},
+	"*=":  {MULEQ, mulOp, 0},
+	"/=":  {DIVEQ, divOp, 0},
+	"%=":  {MODEQ, modOp, 0},
+	"&=":  {ANDEQ, andOp, 0},
+	"^=":  {XOREQ, xorOp, 0},
+	"&^=": {BICEQ, bicOp, 0},
+	"|=":  {OREQ, orOp, 0},
+	"<<=": {LSHIFTEQ, lShiftOp, 0},
+	">>=": {RSHIFTEQ, rShiftOp, 0},
+	"++":  {INC, incOp, 0},
+	"--":  {DEC, decOp, 0},
 }
 
 type list []fun
@@ -402,34 +595,92 @@ func (l list) NewFun() fun {
 	}
 }
 
-type varMap map[string]number
+// varFrame holds the values of variables in slots, which are
+// allocated to variable names at compile time.
+type varFrame struct {
+	names []string       // variable name by slot
+	slots map[string]int // slot by variable name
+	vals  []variable     // variable by slot
+}
+
+type variable struct {
+	n   number
+	set bool // if not set, the variable is undefined
+}
 
-func (vl varMap) NewGet(s string) fun {
-	return func() (number, error) {
-		if n, ok := vl[s]; ok {
-			return n, nil
+// slot returns the slot of the variable s, allocating it if needed.
+func (vf *varFrame) slot(s string) int {
+	if i, ok := vf.slots[s]; ok {
+		return i
+	}
+	i := len(vf.names)
+	vf.slots[s] = i
+	vf.names = append(vf.names, s)
+	vf.vals = append(vf.vals, variable{})
+	return i
+}
+
+func (vf *varFrame) get(i int) (number, error) {
+	if v := &vf.vals[i]; v.set {
+		return v.n, nil
+	}
+	candidates := append([]string(nil), keywords...)
+	for k, v := range vf.vals {
+		if v.set {
+			candidates = append(candidates, vf.names[k])
 		}
-		return number{}, fmt.Errorf("unknown variable %s", s)
+	}
+	return number{}, &UnknownVariableError{
+		Name:       vf.names[i],
+		Suggestion: suggest(vf.names[i], candidates),
 	}
 }
 
-func (vl varMap) NewSet(s string, f fun) fun {
+func (vf *varFrame) set(i int, n number) error {
+	if err := runtime.limits.gas.add(1); err != nil {
+		return err
+	}
+	v := &vf.vals[i]
+	if !v.set {
+		if err := runtime.limits.vars.add(1); err != nil {
+			return err
+		}
+	}
+	v.n, v.set = n, true
+	return nil
+}
+
+func (vf *varFrame) NewGet(s string) fun {
+	i := vf.slot(s)
+	return func() (number, error) {
+		return vf.get(i)
+	}
+}
+
+func (vf *varFrame) NewSet(s string, f fun) fun {
+	i := vf.slot(s)
 	return func() (number, error) {
 		n, err := f()
 		if err != nil {
 			return number{}, err
 		}
-		vl[s] = n
+		if err := vf.set(i, n); err != nil {
+			return number{}, err
+		}
 		return n, nil
 	}
 }
 
 var runtime = struct {
-	top  fun
-	vars varMap
-	eof  bool
+	prog   node
+	top    fun
+	vars   varFrame
+	eof    bool
+	limits struct {
+		gas, loops, vars, depth, output limit
+	}
 }{
-	vars: make(varMap),
+	vars: varFrame{slots: make(map[string]int)},
 }
 
 func cmdEOF() (number, error) {
@@ -438,105 +689,171 @@ func cmdEOF() (number, error) {
 }
 
 type token struct {
-	typ int
-	s   string
-	n   number
-	op  op
-	fun fun
+	typ      int
+	s        string
+	n        number
+	op       op
+	fun      fun
+	pos, end pos   // position of s
+	err      error // why an invalid token is invalid
 }
 
 type yyLex struct {
-	r    io.Reader     // input
-	tty  bool          // interactive session with a human at a teletype
-	in   chan string   // channel for input lines
-	c    chan token    // channel for tokens sent to the parser
-	done chan struct{} // channel for parser done signal
-	s    string        // input string
-	next token         // next token to send
-	last token         // last token sent
+	r          *bufio.Reader // input
+	file       string        // input file name
+	line       int           // current line number
+	col        int           // offset of yy.s in the current line
+	tty        bool          // interactive session with a human at a teletype
+	s          string        // input string
+	inLine     bool          // the end of the input line is not reached
+	eof        bool          // the input is at EOF
+	depth      int           // nesting depth of blocks
+	pending    []token       // tokens to send before reading on
+	next       token         // next token to send
+	last       token         // last token sent
+	tok        token         // last token received by the parser
+	prev       token         // token received before tok
+	inFor      bool          // parser is in a for clause
+	forNewline token         // semicolon inserted at a newline in a for clause
+	errors     int           // syntax errors in the current program
+	tokens     int           // tokens read in the current program
+	src        source        // input lines read so far
+	diag       *diagnostics  // error reporting
+	errh       func(error)   // error handler, diag.report by default
+	comments   []token       // comments read so far, with -fmt
+	lr         *lrTracer     // parse tracer, with -lrtrace
+	want       chan struct{} // requests for tokens, with -lexer=chan
+	c          chan token    // tokens sent to the parser, with -lexer=chan
 }
 
 func newLexer(r io.Reader) *yyLex {
-	yy := yyLex{
-		r:    r,
-		in:   make(chan string),
-		c:    make(chan token),
-		done: make(chan struct{}),
-	}
+	yy := yyLex{r: bufio.NewReader(r)}
 	if f, ok := r.(*os.File); ok {
+		yy.file = f.Name()
+		if f == os.Stdin {
+			yy.file = "<stdin>"
+		}
 		yy.tty = isatty.IsTerminal(f.Fd())
 	}
+	yy.diag = newDiagnostics(os.Stderr, &yy.src)
+	yy.errh = yy.diag.report
+	if *lexerFlag == "chan" {
+		yy.want, yy.c = make(chan struct{}), make(chan token)
+		go yy.send()
+	}
 	return &yy
 }
 
-func (yy *yyLex) Lex(yylval *yySymType) int {
-	tok := <-yy.c
-	switch tok.typ {
-	case NUM:
-		yylval.num = tok.n
-	case IDENT:
-		yylval.word = tok.s
-	case CMD:
-		yylval.fun = tok.fun
-	default:
-		yylval.op = tok.op
+// send sends a token to the parser for each request, from a goroutine
+// of its own, the way the lexer of stage 5 does, to compare the cost
+// of handing tokens over a channel with calling the lexer.  Unlike in
+// stage 5, the lexer only runs while the parser waits for a token, so
+// that the parser may reset it between programs.
+func (yy *yyLex) send() {
+	for range yy.want {
+		yy.c <- yy.lex()
 	}
-	return tok.typ
 }
 
-func (yy *yyLex) Error(s string) {
-	fmt.Fprintln(os.Stderr, s)
+func (yy *yyLex) Lex(yylval *yySymType) int {
+	yylval.tok = yy.read()
+	return yylval.tok.typ
 }
 
-func (yy *yyLex) sendToken() bool {
-	select {
-	case <-yy.done:
-		return false
-	case yy.c <- yy.next:
-		yy.last = yy.next
-		return true
+// read returns the next token for the parser.
+func (yy *yyLex) read() token {
+	if yy.givenUp() {
+		return token{}
+	}
+	var tok token
+	if yy.c != nil {
+		yy.want <- struct{}{}
+		tok = <-yy.c
+	} else {
+		tok = yy.lex()
+	}
+	yy.tokens++
+	if *tokensFlag {
+		fmt.Printf("%s: %v\n", tok.pos, tok)
 	}
+	if yy.lr != nil {
+		yy.lr.token(tok)
+	}
+	if yy.tok.typ == '{' {
+		// the parser took the '{' without an error, ending the for
+		// clause if there was one; an error at the '{' itself is
+		// reported before this and needs to know about the clause
+		yy.inFor, yy.forNewline = false, token{}
+	}
+	yy.prev, yy.tok = yy.tok, tok
+	switch tok.typ {
+	case FOR:
+		yy.inFor = true
+	case ';':
+		if yy.inFor && tok.s == "" && yy.forNewline.typ == 0 {
+			yy.forNewline = tok
+		}
+	}
+	return tok
 }
 
-func (yy *yyLex) send(tok token) bool {
-	yy.next = tok
-	return yy.sendToken()
+// givenUp reports whether the lexer ends the program early because of
+// syntax errors.  An interactive session gives up after the first one,
+// like the grammar without error rules, and starts again on the next
+// line.
+func (yy *yyLex) givenUp() bool {
+	return yy.tty && yy.errors > 0 ||
+		*maxErrorsFlag > 0 && yy.errors >= *maxErrorsFlag
 }
 
-// sendEnd sends an $end token and waits for parser done signal.
-func (yy *yyLex) sendEnd() {
-	if yy.send(token{}) {
-		<-yy.done
+func (yy *yyLex) Error(s string) {
+	// after an error rule, the $end of giving up is an error too
+	if yy.givenUp() {
+		return
+	}
+	err := yy.syntaxError(s)
+	yy.inFor, yy.forNewline = false, token{}
+	yy.errh(err)
+	yy.errors++
+	if yy.errors == *maxErrorsFlag {
+		yy.errh(&SyntaxError{Pos: pos{file: yy.file}, Msg: "too many errors"})
 	}
 }
 
-func (yy *yyLex) input() {
-	sc := bufio.NewScanner(yy.r)
-	for sc.Scan() {
-		s := sc.Text()
-		if s == "" {
-			s = " "
-		}
-		yy.in <- s
-	}
-	if err := sc.Err(); err != nil {
-		fmt.Fprintln(os.Stderr, err)
-	}
-	yy.in <- ""
+// pos returns the current position of the lexer.
+func (yy *yyLex) pos() pos {
+	return pos{file: yy.file, line: yy.line, col: yy.col + 1}
 }
 
+// getLine reads the next input line, of any length, without its
+// "\n" or "\r\n".  The last line may have no newline.  It returns
+// false at EOF.
 func (yy *yyLex) getLine() bool {
-	select {
-	case <-yy.done:
+	s, err := yy.r.ReadString('\n')
+	if err != nil && (err != io.EOF || s == "") {
+		if err != io.EOF {
+			yy.errh(err)
+		}
 		return false
-	case yy.s = <-yy.in:
-		return true
 	}
+	s = strings.TrimSuffix(s, "\n")
+	yy.s = strings.TrimSuffix(s, "\r")
+	yy.src.add(yy.s)
+	yy.line++
+	yy.col = 0
+	return true
 }
 
 func (yy *yyLex) nextToken() bool {
-	s := strings.TrimSpace(yy.s)
+	s := strings.TrimLeftFunc(yy.s, unicode.IsSpace)
+	yy.col += len(yy.s) - len(s)
 	if s == "" || s[0] == '#' {
+		if s != "" && *fmtFlag {
+			yy.comments = append(yy.comments, token{
+				s:   strings.TrimRightFunc(s, unicode.IsSpace),
+				pos: yy.pos(),
+			})
+		}
 		return false
 	}
 	var (
@@ -562,7 +879,7 @@ func (yy *yyLex) nextToken() bool {
 			tok.n.f = f
 			tok.n.isFloat = true
 		} else {
-			fmt.Fprintln(os.Stderr, err)
+			tok.err = err
 		}
 	case s[0] >= 'a' && s[0] <= 'z':
 		for tlen < len(s) && s[tlen] >= 'a' && s[tlen] <= 'z' {
@@ -576,99 +893,250 @@ func (yy *yyLex) nextToken() bool {
 			tok.typ = IDENT
 		}
 	}
+	tok.pos = yy.pos()
 	tok.s, yy.s = s[:tlen], s[tlen:]
+	yy.col += tlen
+	tok.end = yy.pos()
 	yy.next = tok
 	return true
 }
 
-func (yy *yyLex) run() {
-	var (
-		depth int
-		first bool
-	)
+// lex returns the next token for the parser, reading input lines as
+// needed.  At the end of a line it inserts a semicolon, unless the line
+// ends with one or has no tokens.  In an interactive session it also
+// sends $end after every line outside blocks, so that the line runs.
+// At EOF it sends $end, then the EOF command and $end again.
+func (yy *yyLex) lex() token {
+	var tok token
 	for {
-		if !yy.getLine() {
-			goto reset
-		} else if yy.s == "" {
+		if len(yy.pending) > 0 {
+			tok = yy.pending[0]
+			n := copy(yy.pending, yy.pending[1:])
+			yy.pending = yy.pending[:n]
 			break
 		}
-		first = true
-		for yy.nextToken() {
-			for !yy.sendToken() {
-				/*
-				 * when sending the first token in an input
-				 * line fails, it means the error is on the
-				 * previous line.  if in an interactive
-				 * session, reset depth and try sending again.
-				 */
-				if first && yy.tty {
-					depth = 0
-					continue
-				}
-				// otherwise reset (skip line or bail out)
-				goto reset
-			}
-			switch yy.last.typ {
-			case 0, 1:
-				// sent $end or $unk: wait for done and reset
-				<-yy.done
-				goto reset
-			case '{':
-				depth++
-			case '}':
-				depth--
-			}
-			first = false
-		}
-		// end of line
-		switch yy.last.typ {
-		case 0, 1:
-			// if we haven't sent any tokens, read next line
-			continue
-		case ';':
-			// no semicolon needed
-		default:
-			// inject semicolon at EOL
-			if !yy.send(token{typ: ';'}) {
-				goto reset
-			}
-		}
-		if yy.tty && depth <= 0 {
-			// interactive and not within a block:
-			// send $end and reset depth
-			yy.sendEnd()
-			depth = 0
-		}
-		continue
-	reset:
-		if !yy.tty {
+		if yy.inLine && yy.nextToken() {
+			tok = yy.next
 			break
 		}
-		depth = 0
+		switch {
+		case yy.inLine:
+			yy.inLine = false
+			yy.endLine()
+		case yy.eof:
+			// the parser reads past the EOF command
+			yy.pending = append(yy.pending, yy.end())
+		case yy.getLine():
+			yy.inLine = true
+		default:
+			yy.eof = true
+			yy.pending = append(yy.pending, yy.end(),
+				token{typ: CMD, fun: cmdEOF, pos: yy.pos()}, yy.end())
+		}
+	}
+	yy.last = tok
+	switch tok.typ {
+	case '{':
+		yy.depth++
+	case '}':
+		yy.depth--
+	}
+	return tok
+}
+
+// end returns an $end token at the current position.
+func (yy *yyLex) end() token {
+	return token{pos: yy.pos(), end: yy.pos()}
+}
+
+// endLine queues the tokens to send at the end of an input line.
+func (yy *yyLex) endLine() {
+	switch yy.last.typ {
+	case 0:
+		// no tokens sent since the last $end
+		return
+	case ';':
+		// no semicolon needed
+	default:
+		// inject semicolon at EOL
+		yy.pending = append(yy.pending,
+			token{typ: ';', pos: yy.pos(), end: yy.pos()})
+	}
+	if yy.tty && yy.depth <= 0 {
+		// interactive and not within a block:
+		// send $end and reset depth
+		yy.pending = append(yy.pending, yy.end())
+		yy.depth = 0
 	}
-	// EOF
-	// we could check yy.last here to avoid sending $end
-	// after $end or $unk, but this is simpler and more robust.
-	yy.sendEnd()                          // send $end
-	yy.send(token{typ: CMD, fun: cmdEOF}) // send EOF command
-	yy.sendEnd()                          // send $end
+}
+
+// endProgram is called when the parser is done with a program.  If the
+// parser stopped before $end, giving up after a syntax error,
+// an interactive session skips the rest of the line and resets the
+// depth, and otherwise the input ends there.
+func (yy *yyLex) endProgram() {
+	if yy.last.typ == 0 {
+		return
+	}
+	yy.s, yy.inLine, yy.pending = "", false, yy.pending[:0]
+	if yy.tty {
+		yy.depth = 0
+		return
+	}
+	yy.eof = true
+	yy.pending = append(yy.pending, yy.end(),
+		token{typ: CMD, fun: cmdEOF, pos: yy.pos()}, yy.end())
 }
 
 func (yy *yyLex) parse() {
-	go yy.input()
-	go yy.run()
+	if *lrTraceFlag != "" {
+		yy.lr = newLRTracer(*lrTraceFlag)
+	}
 	for !runtime.eof {
-		if yyParse(yy) == 0 {
-			if _, err := runtime.top(); err != nil {
-				fmt.Fprintln(os.Stderr, err)
+		yy.errors = 0
+		if yy.lr != nil {
+			yy.lr.start()
+		}
+		// with syntax errors the parser recovers to report them
+		// all, but the program is not run
+		yy.tokens = 0
+		parseStart := time.Now()
+		ok := parsers[*parserFlag](yy) == 0 && yy.errors == 0
+		if _, cmd := runtime.prog.(*command); *timeFlag && !cmd && yy.tokens > 0 {
+			d := time.Since(parseStart)
+			fmt.Fprintf(os.Stderr, "parse time: %v, %d tokens, %v per token\n",
+				d, yy.tokens, d/time.Duration(yy.tokens))
+		}
+		if yy.lr != nil {
+			if err := yy.lr.end(); err != nil {
+				yy.errh(err)
 			}
 		}
-		yy.done <- struct{}{}
+		if ok {
+			if _, ok := runtime.prog.(*command); !ok && *vetFlag {
+				for _, w := range vet(runtime.prog, !yy.tty) {
+					yy.errh(w)
+				}
+			}
+			if _, ok := runtime.prog.(*command); !ok && *astFlag != "" {
+				if err := dumpAST(os.Stdout, runtime.prog, *astFlag); err != nil {
+					yy.errh(err)
+				}
+				yy.endProgram()
+				continue
+			}
+			if b, ok := runtime.prog.(*block); ok && *fmtFlag {
+				if err := formatSource(os.Stdout, b, yy.comments); err != nil {
+					yy.errh(err)
+				}
+				yy.endProgram()
+				continue
+			}
+			if *optimiseFlag || *emitFlag != "" {
+				runtime.prog = optimise(runtime.prog)
+			}
+			if _, ok := runtime.prog.(*command); !ok && *emitFlag != "" {
+				if err := emitGo(os.Stdout, runtime.prog); err != nil {
+					yy.errh(err)
+				}
+				yy.endProgram()
+				continue
+			}
+			// each program has its own gas, loop iterations and
+			// output, but the variables it sets stay for the next
+			limits := &runtime.limits
+			limits.gas.n, limits.loops.n, limits.output.n = 0, 0, 0
+			runtime.top = backends[*backendFlag](runtime.prog)
+			start := time.Now()
+			_, err := runtime.top()
+			if *timeFlag && !runtime.eof {
+				fmt.Fprintln(os.Stderr, "run time:", time.Since(start))
+			}
+			if err != nil {
+				yy.errh(err)
+			}
+		}
+		yy.endProgram()
 	}
 }
 
+var (
+	optimiseFlag = flag.Bool("O", true, "optimise the program")
+	typedFlag    = flag.Bool("typed", true,
+		"specialise closures for statically known types")
+	timeFlag = flag.Bool("time", false,
+		"print parse and run time of each program")
+	backendFlag = flag.String("backend", "closure",
+		"run programs using `closure` or vm")
+	disasmFlag = flag.Bool("disasm", false,
+		"print the bytecode of each program with -backend=vm")
+	maxErrorsFlag = flag.Int("maxerrors", 10,
+		"stop parsing after `n` syntax errors (0 for no limit)")
+	emitFlag = flag.String("emit", "",
+		"translate the input to `go` source instead of running it")
+	tokensFlag = flag.Bool("tokens", false,
+		"print the tokens read by the parser")
+	parserFlag = flag.String("parser", "yacc",
+		"parse programs using the `yacc` or pratt parser")
+	lexerFlag = flag.String("lexer", "pull",
+		"have the parser `pull` tokens from the lexer, or read them\n"+
+			"over a chan from a goroutine (for comparing with make bench)")
+)
+
+var backends = map[string]func(node) fun{
+	"closure": compile,
+	"vm":      compileVM,
+}
+
+var parsers = map[string]func(*yyLex) int{
+	"yacc":  func(yy *yyLex) int { return yyParse(yy) },
+	"pratt": prattParse,
+}
+
 func main() {
+	limits := &runtime.limits
+	limits.gas.name = "gas"
+	limits.loops.name = "loop iterations"
+	limits.vars.name = "variables"
+	limits.depth.name = "loop depth"
+	limits.output.name = "output size"
+	flag.IntVar(&limits.gas.max, "gas", 0,
+		"maximum number of evaluated operations per program")
+	flag.IntVar(&limits.loops.max, "loops", 0,
+		"maximum number of loop iterations per program")
+	flag.IntVar(&limits.vars.max, "vars", 0,
+		"maximum number of variables")
+	flag.IntVar(&limits.depth.max, "depth", 0,
+		"maximum depth of nested loops (there are no function calls)")
+	flag.IntVar(&limits.output.max, "output", 0,
+		"maximum output size in bytes per program")
+	flag.Parse()
+	if backends[*backendFlag] == nil {
+		fmt.Fprintln(os.Stderr, "unknown backend", *backendFlag)
+		os.Exit(2)
+	}
+	if parsers[*parserFlag] == nil {
+		fmt.Fprintln(os.Stderr, "unknown parser", *parserFlag)
+		os.Exit(2)
+	}
+	if *lexerFlag != "pull" && *lexerFlag != "chan" {
+		fmt.Fprintln(os.Stderr, "unknown lexer", *lexerFlag)
+		os.Exit(2)
+	}
+	if *emitFlag != "" && *emitFlag != "go" {
+		fmt.Fprintln(os.Stderr, "unknown language", *emitFlag)
+		os.Exit(2)
+	}
+	if *lrTraceFlag != "" && *lrTraceFlag != "text" && *lrTraceFlag != "dot" {
+		fmt.Fprintln(os.Stderr, "unknown trace format", *lrTraceFlag)
+		os.Exit(2)
+	}
+	if *astFlag != "" && dumpFormats[*astFlag] == nil {
+		fmt.Fprintln(os.Stderr, "unknown syntax tree format", *astFlag)
+		os.Exit(2)
+	}
 	yyErrorVerbose = true
+	yyDebug = *yyDebugFlag
 	if false {
 		s := `
 		for i = 0; i < 5; i++ {
@@ -682,5 +1150,9 @@ func main() {
 		return
 	}
 	yy := newLexer(os.Stdin)
+	if *emitFlag != "" || *fmtFlag {
+		// translate or format the whole input as one program
+		yy.tty = false
+	}
 	yy.parse()
 }
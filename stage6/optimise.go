@@ -0,0 +1,144 @@
+package main
+
+// optimise folds constant subexpressions of the tree n, applies safe
+// algebraic identities and removes loops that never run.  It returns
+// the optimised tree, which is nil if nothing is left of n.
+func optimise(n node) node {
+	switch n := n.(type) {
+	case *unary:
+		n.x = optimise(n.x)
+		if a, ok := constNum(n); ok {
+			return newNumLit(n, a)
+		}
+	case *paren:
+		n.x = optimise(n.x)
+		if _, ok := n.x.(*numLit); ok {
+			return keepSpan(n, n.x)
+		}
+	case *binary:
+		n.x, n.y = optimise(n.x), optimise(n.y)
+		return optimiseBinary(n)
+	case *assign:
+		if n.rval != nil {
+			n.rval = optimise(n.rval)
+		}
+	case *printStmt:
+		n.x = optimise(n.x)
+	case *forStmt:
+		if n.init != nil {
+			n.init = optimise(n.init)
+		}
+		n.cond = optimise(n.cond)
+		if a, ok := n.cond.(*numLit); ok && !a.tok.n.Bool() {
+			return n.init
+		}
+		if n.post != nil {
+			n.post = optimise(n.post)
+		}
+		optimise(n.body)
+	case *block:
+		stmts := n.stmts[:0]
+		for _, v := range n.stmts {
+			if v = optimise(v); v != nil {
+				stmts = append(stmts, v)
+			}
+		}
+		n.stmts = stmts
+	}
+	return n
+}
+
+func optimiseBinary(n *binary) node {
+	a, aok := n.x.(*numLit)
+	b, bok := n.y.(*numLit)
+	switch {
+	case aok && bok:
+		f := n.op.op.NewFun(a.tok.n.NewFun(), b.tok.n.NewFun())
+		if v, err := evalConst(f); err == nil {
+			return newNumLit(n, v)
+		}
+		// keep the error for run time
+	case aok && (n.op.typ == LAND || n.op.typ == LOR):
+		// short-circuit logic returns either operand unchanged
+		if a.tok.n.Bool() == (n.op.typ == LOR) {
+			return keepSpan(n, n.x)
+		}
+		return keepSpan(n, n.y)
+	case bok && isIdentity(n.op.typ, b.tok.n, true) && isInt(n.x):
+		return keepSpan(n, n.x)
+	case aok && isIdentity(n.op.typ, a.tok.n, false) && isInt(n.y):
+		return keepSpan(n, n.y)
+	}
+	return n
+}
+
+// isIdentity reports whether the integer a is an identity element
+// of the binary operator typ when on the right or left side.
+func isIdentity(typ int, a number, right bool) bool {
+	if a.isFloat {
+		return false
+	}
+	switch typ {
+	case '+', '|', '^':
+		return a.i == 0
+	case '*':
+		return a.i == 1
+	case '-', BIC, LSHIFT, RSHIFT:
+		return right && a.i == 0
+	case '/':
+		return right && a.i == 1
+	}
+	return false
+}
+
+// isInt reports whether n is known to evaluate to an integer.
+func isInt(n node) bool {
+	switch n := n.(type) {
+	case *numLit:
+		return !n.tok.n.isFloat
+	case *unary:
+		return n.op.typ != '-' || isInt(n.x)
+	case *paren:
+		return isInt(n.x)
+	case *binary:
+		switch n.op.typ {
+		case '&', '|', '^', BIC, LSHIFT, RSHIFT, EQ, NE, '<', '>', LE, GE:
+			return true
+		}
+		return isInt(n.x) && isInt(n.y)
+	}
+	return false
+}
+
+// evalConst runs f at compile time, ignoring resource limits.
+func evalConst(f fun) (number, error) {
+	limits := runtime.limits
+	defer func() { runtime.limits = limits }()
+	runtime.limits.gas.max = 0
+	return f()
+}
+
+// newNumLit returns a number literal for the value a of the constant
+// expression n, spanning n in the source so that diagnostics point at
+// the same text as without optimisation.
+func newNumLit(n node, a number) *numLit {
+	return &numLit{tok: token{
+		typ: NUM,
+		s:   a.String(),
+		n:   a,
+		pos: n.Pos(),
+		end: n.End(),
+	}}
+}
+
+// keepSpan returns x, which n is optimised to, spanning n in the source
+// like newNumLit does.  Other nodes than constants are put in a paren,
+// which adds nothing to the code but has a span of its own.
+func keepSpan(n, x node) node {
+	if a, ok := x.(*numLit); ok {
+		return newNumLit(n, a.tok.n)
+	}
+	rparen := n.End()
+	rparen.col-- // paren.End is the column after it
+	return &paren{lparen: n.Pos(), rparen: rparen, x: x}
+}
@@ -3,59 +3,72 @@ package main
 %}
 
 %union {
-        num  number
-        word string
-        op   op
-        fun  fun
-        list list
+        tok   token
+        node  node
+        nodes []node
+        block *block
 }
 
-%token <num> NUM
-%token <word> IDENT
-%token <fun> CMD
-%token <op> '+' '-' '*' '/' '%' '&' '^' BIC '|' LSHIFT RSHIFT
-%token <op> '!' LAND LOR '<' '>' LE GE EQ NE
-%token <op> '=' ADDEQ SUBEQ MULEQ DIVEQ MODEQ ANDEQ XOREQ BICEQ OREQ
-%token <op> LSHIFTEQ RSHIFTEQ INC DEC FOR
-
-%type <num> num
-%type <op> op3 op4 op5 unop assignop incdec
-%type <fun> stmt stmt2 assign
-%type <fun> expr expr2 expr3 expr4 expr5 expr6 expr7
-%type <list> stmts list block
+// the numbers are those of the constants in tokens.go, which go
+// generate drops from y.go
+%token <tok> NUM 57346
+%token <tok> IDENT 57347
+%token <tok> CMD 57348
+%token <tok> '+' '-' '*' '/' '%' '&' '^' BIC 57349 '|' LSHIFT 57350 RSHIFT 57351
+%token <tok> '!' LAND 57352 LOR 57353 '<' '>' LE 57354 GE 57355 EQ 57356 NE 57357
+%token <tok> '=' ADDEQ 57358 SUBEQ 57359 MULEQ 57360 DIVEQ 57361 MODEQ 57362
+%token <tok> ANDEQ 57363 XOREQ 57364 BICEQ 57365 OREQ 57366
+%token <tok> LSHIFTEQ 57367 RSHIFTEQ 57368 INC 57369 DEC 57370 FOR 57371
+%token <tok> '{' '}' '(' ')'
+
+%type <tok> op3 op4 op5 unop assignop incdec
+%type <node> num list stmt stmt2 assign
+%type <node> expr expr2 expr3 expr4 expr5 expr6 expr7
+%type <nodes> stmts
+%type <block> block
 
 %%
 
 top:
-        stmts                   { runtime.top = $1.NewFun() }
-|       CMD                     { runtime.top = $1 }
+        stmts                   { runtime.prog = &block{stmts: $1} }
+|       CMD                     { runtime.prog = &command{tok: $1} }
 
 stmts:
-                                { }
+                                { $$ = nil }
 |       stmts ';'
 |       stmts stmt ';'          { $$ = append($1, $2) }
-|       stmts list ';'          { $$ = append($1, $2...) }
+|       stmts list ';'          { $$ = append($1, $2) }
+|       stmts error ';'         { Errflag = 0 } // yyerrok
 
 list:
-        block
+        block                   { $$ = $1 }
 |       FOR stmt2 ';' expr ';' stmt2 block
         {
-                $$ = list{$2, $1.NewFun($4, append($7, $6).NewFun())}
+                $$ = &forStmt{tok: $1, init: $2, cond: $4, post: $6, body: $7}
         }
 
-block:  '{' stmts '}'           { $$ = $2 }
+block:
+        '{' stmts '}'
+        {
+                $$ = &block{lbrace: $1.pos, stmts: $2, rbrace: $3.pos}
+        }
+|       '{' stmts error '}'
+        {
+                Errflag = 0 // yyerrok
+                $$ = &block{lbrace: $1.pos, stmts: $2, rbrace: $4.pos}
+        }
 
 stmt:
         stmt2
-|       FOR expr block          { $$ = $1.NewFun($2, $3.NewFun()) }
+|       FOR expr block          { $$ = &forStmt{tok: $1, cond: $2, body: $3} }
 
 stmt2:
         assign
-|       expr                    { $$ = printOp.NewFun($1, nil) }
+|       expr                    { $$ = &printStmt{x: $1} }
 
 assign:
-        IDENT assignop expr     { $$ = NewAssign($1, $2, $3) }
-|       IDENT incdec            { $$ = NewAssign($1, $2, nil) }
+        IDENT assignop expr     { $$ = &assign{name: $1, op: $2, rval: $3} }
+|       IDENT incdec            { $$ = &assign{name: $1, op: $2} }
 
 assignop:
         '=' | ADDEQ | SUBEQ | MULEQ | DIVEQ | MODEQ
@@ -65,42 +78,42 @@ incdec: INC | DEC
 
 expr:
         expr2
-|       expr LOR expr2          { $$ = $2.NewFun($1, $3) }
+|       expr LOR expr2          { $$ = &binary{op: $2, x: $1, y: $3} }
 
 expr2:
         expr3
-|       expr2 LAND expr3        { $$ = $2.NewFun($1, $3) }
+|       expr2 LAND expr3        { $$ = &binary{op: $2, x: $1, y: $3} }
 
 expr3:
         expr4
-|       expr3 op3 expr4         { $$ = $2.NewFun($1, $3) }
+|       expr3 op3 expr4         { $$ = &binary{op: $2, x: $1, y: $3} }
 
 op3:    EQ | NE | '<' | LE | '>' | GE
 
 expr4:
         expr5
-|       expr4 op4 expr5         { $$ = $2.NewFun($1, $3) }
+|       expr4 op4 expr5         { $$ = &binary{op: $2, x: $1, y: $3} }
 
 op4:    '+' | '-' | '|' | '^'
 
 expr5:
         expr6
-|       expr5 op5 expr6         { $$ = $2.NewFun($1, $3) }
+|       expr5 op5 expr6         { $$ = &binary{op: $2, x: $1, y: $3} }
 
 op5:    '*' | '/' | '%' | '&' | BIC | LSHIFT | RSHIFT
 
 expr6:
         expr7
-|       num                     { $$ = $1.NewFun() }
+|       num
 
 num:
-        NUM
-|       unop num                { $$ = $2.RunUnary($1) }
+        NUM                     { $$ = &numLit{tok: $1} }
+|       unop num                { $$ = &unary{op: $1, x: $2} }
 
 expr7:
-        '(' expr ')'            { $$ = $2 }
-|       IDENT                   { $$ = runtime.vars.NewGet($1) }
-|       unop expr7              { $$ = $1.NewFun($2, nil) }
+        '(' expr ')'            { $$ = &paren{lparen: $1.pos, x: $2, rparen: $3.pos} }
+|       IDENT                   { $$ = &ident{tok: $1} }
+|       unop expr7              { $$ = &unary{op: $1, x: $2} }
 
 unop:    '-' | '^' | '!'
 
@@ -0,0 +1,210 @@
+package main
+
+// prattParser is a hand-written parser for the grammar in parse.y,
+// building the same syntax tree as the goyacc parser without its
+// tables.  Statements are parsed by recursive descent, and expressions
+// by precedence climbing, Pratt style, on the precedences of the
+// binary operators in ops.
+//
+// Syntax errors are recovered from as the error rules of the grammar
+// do: the statement list the error is in skips the tokens up to the
+// next ';', or the '}' ending its block.  So both parsers report the
+// same errors at the same positions, but the messages of this one
+// don't list the expected tokens.
+type prattParser struct {
+	yy  *yyLex
+	tok token // lookahead
+}
+
+// prattError unwinds the parser to the statement list after a syntax
+// error, or out of the parser if abort is set.
+type prattError struct {
+	abort bool
+}
+
+// prattParse parses a program from the tokens of yy, and sets
+// runtime.prog to it.  Like yyParse, it returns 0 if the program is
+// parsed, even after recovering from syntax errors, and 1 if it runs
+// out of input in error recovery.
+func prattParse(yy *yyLex) (ret int) {
+	p := &prattParser{yy: yy}
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(prattError); !ok {
+				panic(e)
+			}
+			ret = 1
+		}
+	}()
+	p.next()
+	if p.tok.typ == CMD {
+		runtime.prog = &command{tok: p.tok}
+		p.next()
+		if p.tok.typ != 0 {
+			p.error()
+		}
+		return 0
+	}
+	runtime.prog = &block{stmts: p.stmts(0)}
+	return 0
+}
+
+// next reads the next token.
+func (p *prattParser) next() {
+	p.tok = p.yy.read()
+}
+
+// error reports the lookahead as unexpected, and unwinds to the
+// statement list.
+func (p *prattParser) error() {
+	p.yy.Error("syntax error: unexpected " + tokenName(p.tok.typ))
+	panic(prattError{})
+}
+
+// expect reads the token of type typ.
+func (p *prattParser) expect(typ int) token {
+	tok := p.tok
+	if tok.typ != typ {
+		p.error()
+	}
+	p.next()
+	return tok
+}
+
+// stmts parses statements up to the token end, which is '}' in a block
+// and $end in a program.
+func (p *prattParser) stmts(end int) []node {
+	var stmts []node
+	for p.tok.typ != end {
+		if n := p.stmt(end); n != nil {
+			stmts = append(stmts, n)
+		}
+	}
+	return stmts
+}
+
+// stmt parses a statement followed by ';', or an empty statement,
+// for which it returns nil.  After a syntax error it skips the rest
+// of the statement, like the rules
+//
+//	stmts:  stmts error ';'
+//	block:  '{' stmts error '}'
+//
+// and returns nil too.
+func (p *prattParser) stmt(end int) (n node) {
+	defer func() {
+		e := recover()
+		if e == nil {
+			return
+		}
+		if e, ok := e.(prattError); !ok || e.abort {
+			panic(e)
+		}
+		n = nil
+		for p.tok.typ != ';' && (end == 0 || p.tok.typ != end) {
+			if p.tok.typ == 0 {
+				panic(prattError{abort: true})
+			}
+			p.next()
+		}
+		if p.tok.typ == ';' {
+			p.next()
+		}
+	}()
+	switch p.tok.typ {
+	case ';':
+		p.next()
+		return nil
+	case '{':
+		n = p.block()
+	case FOR:
+		n = p.forStmt()
+	default:
+		n = p.simpleStmt()
+	}
+	p.expect(';')
+	return n
+}
+
+func (p *prattParser) block() *block {
+	lbrace := p.expect('{')
+	stmts := p.stmts('}')
+	rbrace := p.expect('}')
+	return &block{lbrace: lbrace.pos, stmts: stmts, rbrace: rbrace.pos}
+}
+
+// forStmt parses both forms of for loops.  Whether the first
+// statement in the clause is the condition shows after it, when the
+// block starts.
+func (p *prattParser) forStmt() node {
+	tok := p.expect(FOR)
+	init := p.simpleStmt()
+	if s, ok := init.(*printStmt); ok && p.tok.typ == '{' {
+		return &forStmt{tok: tok, cond: s.x, body: p.block()}
+	}
+	p.expect(';')
+	cond := p.expr()
+	p.expect(';')
+	post := p.simpleStmt()
+	return &forStmt{tok: tok, init: init, cond: cond, post: post,
+		body: p.block()}
+}
+
+// simpleStmt parses an assignment or an expression statement.  Both
+// can start with an identifier, and the token after it tells which.
+func (p *prattParser) simpleStmt() node {
+	if p.tok.typ != IDENT {
+		return &printStmt{x: p.expr()}
+	}
+	name := p.expect(IDENT)
+	switch typ := p.tok.typ; {
+	case typ == INC || typ == DEC:
+		return &assign{name: name, op: p.expect(typ)}
+	case isAssignOp(typ):
+		op := p.expect(typ)
+		return &assign{name: name, op: op, rval: p.expr()}
+	}
+	return &printStmt{x: p.binary(&ident{tok: name}, 1)}
+}
+
+func (p *prattParser) expr() node {
+	return p.binary(p.unary(), 1)
+}
+
+// binary parses the rest of the expression starting with the
+// operand x, taking the binary operators of precedence prec and
+// higher.  The operators of the same precedence are left associative.
+func (p *prattParser) binary(x node, prec int) node {
+	for {
+		q := precedence(p.tok.typ)
+		if q < prec || q == 0 {
+			return x
+		}
+		op := p.expect(p.tok.typ)
+		y := p.binary(p.unary(), q+1)
+		x = &binary{op: op, x: x, y: y}
+	}
+}
+
+// unary parses an operand of a binary operator: a number, a variable,
+// a parenthesised expression or a unary operator applied to those.
+func (p *prattParser) unary() node {
+	switch tok := p.tok; {
+	case tok.typ == NUM:
+		p.next()
+		return &numLit{tok: tok}
+	case tok.typ == IDENT:
+		p.next()
+		return &ident{tok: tok}
+	case tok.typ == '(':
+		p.next()
+		x := p.expr()
+		rparen := p.expect(')')
+		return &paren{lparen: tok.pos, x: x, rparen: rparen.pos}
+	case isUnaryOp(tok.typ):
+		p.next()
+		return &unary{op: tok, x: p.unary()}
+	}
+	p.error()
+	panic("not reached")
+}
@@ -0,0 +1,34 @@
+# rules.awk extracts the grammar rules, numbered as in the parser
+# tables, from goyacc's y.output, for naming reductions in -lrtrace.
+# Every rule shows up completed, "lhs: rhs.    (n)", in some state.
+
+/^\t\$accept: \./ {
+	s = substr($0, 2)
+	sub(/: \./, ": ", s)
+	sub(/ +$/, "", s)
+	rule[0] = s
+}
+
+/^\t.*\.    \([0-9]+\)$/ {
+	n = substr($NF, 2, length($NF) - 2)
+	s = substr($0, 2)
+	sub(/\.    \([0-9]+\)$/, "", s)
+	sub(/: +/, ": ", s)
+	sub(/ +$/, "", s)
+	gsub(/[\\"]/, "\\\\&", s)
+	rule[n] = s
+	if (n + 0 > max)
+		max = n + 0
+}
+
+END {
+	print "// Code generated by rules.awk from y.output. DO NOT EDIT."
+	print ""
+	print "package main"
+	print ""
+	print "// yyRules are the grammar rules by number."
+	print "var yyRules = [...]string{"
+	for (i = 0; i <= max; i++)
+		printf "\t\"%s\",\n", rule[i]
+	print "}"
+}
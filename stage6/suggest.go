@@ -0,0 +1,52 @@
+package main
+
+// keywords are the reserved words.  Misspelt, the lexer takes them
+// for identifiers.
+var keywords = []string{"for"}
+
+// editDistance returns the number of single character insertions,
+// deletions, substitutions and transpositions of adjacent characters
+// needed to turn a into b (the optimal string alignment distance).
+func editDistance(a, b string) int {
+	// d[i][j] is the distance between a[:i] and b[:j]
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// suggest returns the candidate closest to s, if it's close enough
+// to be a likely misspelling, or "".
+func suggest(s string, candidates []string) string {
+	var (
+		best  string
+		bestD = max(1, len(s)/3) + 1
+	)
+	for _, c := range candidates {
+		d := editDistance(s, c)
+		if d == 0 || d >= len(s) {
+			continue
+		}
+		if d < bestD || d == bestD && best != "" && c < best {
+			best, bestD = c, d
+		}
+	}
+	return best
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tokenSpellings maps goyacc token names to how they are spelt in the
+// source, or what they are.  Character tokens like '+' are spelt as
+// they are named.
+var tokenSpellings = map[string]string{
+	"$end":     "end of input",
+	"$unk":     "invalid token",
+	"NUM":      "number",
+	"IDENT":    "identifier",
+	"BIC":      "'&^'",
+	"LSHIFT":   "'<<'",
+	"RSHIFT":   "'>>'",
+	"LAND":     "'&&'",
+	"LOR":      "'||'",
+	"LE":       "'<='",
+	"GE":       "'>='",
+	"EQ":       "'=='",
+	"NE":       "'!='",
+	"ADDEQ":    "'+='",
+	"SUBEQ":    "'-='",
+	"MULEQ":    "'*='",
+	"DIVEQ":    "'/='",
+	"MODEQ":    "'%='",
+	"ANDEQ":    "'&='",
+	"XOREQ":    "'^='",
+	"BICEQ":    "'&^='",
+	"OREQ":     "'|='",
+	"LSHIFTEQ": "'<<='",
+	"RSHIFTEQ": "'>>='",
+	"INC":      "'++'",
+	"DEC":      "'--'",
+	"FOR":      "'for'",
+}
+
+// hiddenTokens are sent by the lexer and never typed in.
+var hiddenTokens = map[string]bool{
+	"CMD": true,
+}
+
+func spellToken(name string) string {
+	if s, ok := tokenSpellings[name]; ok {
+		return s
+	}
+	return name
+}
+
+// String returns the name, text and value of tok for -tokens, like
+// NUM "2.5" 2.5.  Tokens the lexer makes up, which are not in the
+// source, are marked in brackets.
+func (tok token) String() string {
+	name := tokenName(tok.typ)
+	switch {
+	case tok.typ == 0:
+		return name + " [end of program]"
+	case tok.typ == CMD:
+		return name + " [EOF command]"
+	case tok.typ == ';' && tok.s == "":
+		return name + " [inserted at end of line]"
+	case tok.typ == NUM:
+		return fmt.Sprintf("%s %q %v", name, tok.s, tok.n)
+	}
+	return fmt.Sprintf("%s %q", name, tok.s)
+}
+
+// describe returns a description of tok as it appears in the source.
+func (tok token) describe() string {
+	switch {
+	case tok.typ == 0 || tok.typ == CMD:
+		return "end of input"
+	case tok.typ == ';' && tok.s == "":
+		return "newline"
+	case tok.typ == NUM:
+		return "number " + tok.s
+	case tok.typ == IDENT:
+		return "identifier " + tok.s
+	case tok.typ == 1 && tok.s[0] >= '0' && tok.s[0] <= '9':
+		return "invalid number " + tok.s
+	case tok.typ == 1:
+		return fmt.Sprintf("invalid character %q", tok.s)
+	}
+	return "'" + tok.s + "'"
+}
+
+// binaryPrec maps the token types of the binary operators to their
+// precedence in ops.
+var binaryPrec = ops.precedences()
+
+// precedence returns the precedence of the binary operator typ, or 0
+// if typ is not one.
+func precedence(typ int) int {
+	return binaryPrec[typ]
+}
+
+func isUnaryOp(typ int) bool {
+	return typ == '-' || typ == '^' || typ == '!'
+}
+
+func isAssignOp(typ int) bool {
+	switch typ {
+	case '=', ADDEQ, SUBEQ, MULEQ, DIVEQ, MODEQ, ANDEQ, XOREQ, BICEQ,
+		OREQ, LSHIFTEQ, RSHIFTEQ, INC, DEC:
+		return true
+	}
+	return false
+}
+
+// syntaxError turns the goyacc error message s, like
+// "syntax error: unexpected LSHIFTEQ, expecting IDENT or NUM",
+// into an error in terms of the source: "syntax error: unexpected
+// '<<=', expecting identifier or number".  Some common mistakes get
+// messages of their own.  Invalid tokens are LexErrors.
+func (yy *yyLex) syntaxError(s string) error {
+	tok := yy.tok
+	if tok.typ == 1 {
+		return &LexError{Pos: tok.pos, End: tok.end, Text: tok.s, Err: tok.err}
+	}
+	rest, ok := strings.CutPrefix(s, "syntax error: unexpected ")
+	if !ok {
+		msg := strings.TrimPrefix(strings.TrimPrefix(s, "syntax error"), ": ")
+		return &SyntaxError{Pos: tok.pos, End: tok.end, Msg: msg}
+	}
+	_, list, _ := strings.Cut(rest, ", expecting ")
+	var (
+		expected []string
+		brace    bool
+	)
+	if list != "" {
+		for _, name := range strings.Split(list, " or ") {
+			if !hiddenTokens[name] {
+				expected = append(expected, spellToken(name))
+			}
+			brace = brace || name == "'{'"
+		}
+	}
+	if yy.forNewline.typ != 0 {
+		// the newline was taken for a ';' in "for init; cond; post"
+		tok, brace = yy.forNewline, true
+	}
+	e := &SyntaxError{Pos: tok.pos, End: tok.end, Unexpected: tok.describe()}
+	prev := yy.prev
+	switch {
+	case prev.typ == IDENT && suggest(prev.s, keywords) != "":
+		// like "fro i = 0; i < 3; i++ {"
+		e.Pos, e.End = prev.pos, prev.end
+		e.Msg = prev.s + " is not a keyword"
+		e.Suggestion = suggest(prev.s, keywords)
+	case yy.inFor && brace:
+		e.Msg = "expected '{' after for clause, found " + e.Unexpected
+	case isAssignOp(tok.typ) && !isAssignOp(prev.typ):
+		e.Msg = fmt.Sprintf("cannot assign to an expression, "+
+			"the left side of '%s' must be a variable", tok.s)
+	default:
+		e.Msg = "unexpected " + e.Unexpected
+		e.Expected = expected
+	}
+	return e
+}
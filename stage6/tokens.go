@@ -0,0 +1,86 @@
+package main
+
+import (
+	"strings"
+)
+
+// Token types of the lexer, other than characters like '+' and the
+// $end of a program, which is 0.  Invalid tokens are 1, as goyacc has
+// no type for them.  The numbers are given to the tokens in parse.y
+// as well, and go generate drops the constants goyacc declares for
+// them, so that the lexer and the Pratt parser build without y.go.
+const (
+	NUM = 57346 + iota
+	IDENT
+	CMD
+	BIC
+	LSHIFT
+	RSHIFT
+	LAND
+	LOR
+	LE
+	GE
+	EQ
+	NE
+	ADDEQ
+	SUBEQ
+	MULEQ
+	DIVEQ
+	MODEQ
+	ANDEQ
+	XOREQ
+	BICEQ
+	OREQ
+	LSHIFTEQ
+	RSHIFTEQ
+	INC
+	DEC
+	FOR
+)
+
+// tokenNames maps the token types other than characters to their
+// names in parse.y.
+var tokenNames = map[int]string{
+	0:        "$end",
+	NUM:      "NUM",
+	IDENT:    "IDENT",
+	CMD:      "CMD",
+	BIC:      "BIC",
+	LSHIFT:   "LSHIFT",
+	RSHIFT:   "RSHIFT",
+	LAND:     "LAND",
+	LOR:      "LOR",
+	LE:       "LE",
+	GE:       "GE",
+	EQ:       "EQ",
+	NE:       "NE",
+	ADDEQ:    "ADDEQ",
+	SUBEQ:    "SUBEQ",
+	MULEQ:    "MULEQ",
+	DIVEQ:    "DIVEQ",
+	MODEQ:    "MODEQ",
+	ANDEQ:    "ANDEQ",
+	XOREQ:    "XOREQ",
+	BICEQ:    "BICEQ",
+	OREQ:     "OREQ",
+	LSHIFTEQ: "LSHIFTEQ",
+	RSHIFTEQ: "RSHIFTEQ",
+	INC:      "INC",
+	DEC:      "DEC",
+	FOR:      "FOR",
+}
+
+// charTokens are the character tokens of the grammar.
+const charTokens = "!%&()*+-/;<=>^{|}"
+
+// tokenName returns the goyacc name of the token type typ,
+// like "IDENT" or "'+'", or "$unk" if the grammar has no such token.
+func tokenName(typ int) string {
+	if s, ok := tokenNames[typ]; ok {
+		return s
+	}
+	if typ > 1 && typ < 128 && strings.IndexByte(charTokens, byte(typ)) >= 0 {
+		return "'" + string(rune(typ)) + "'"
+	}
+	return "$unk"
+}
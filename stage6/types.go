@@ -0,0 +1,134 @@
+package main
+
+// numType is the statically inferred type of an expression.
+type numType uint8
+
+const (
+	typeUnknown numType = iota // never has a value
+	typeInt
+	typeFloat
+	typeDynamic // known only at run time
+)
+
+func typeOfNumber(a number) numType {
+	if a.isFloat {
+		return typeFloat
+	}
+	return typeInt
+}
+
+// join returns the type of a value that has either type t or u.
+func (t numType) join(u numType) numType {
+	switch {
+	case t == typeUnknown:
+		return u
+	case u == typeUnknown || t == u:
+		return t
+	}
+	return typeDynamic
+}
+
+// binaryType returns the type of the result of the binary
+// or assignment operator typ applied to operands of types x and y.
+func binaryType(typ int, x, y numType) numType {
+	switch typ {
+	case '+', '-', '*', '/', '%', ADDEQ, SUBEQ, MULEQ, DIVEQ, MODEQ:
+		switch {
+		case x == typeUnknown || y == typeUnknown:
+			return typeUnknown
+		case x == typeFloat || y == typeFloat:
+			return typeFloat
+		case x == typeDynamic || y == typeDynamic:
+			return typeDynamic
+		}
+	case LAND, LOR:
+		return x.join(y)
+	}
+	return typeInt
+}
+
+// typeInfo holds the inferred types of variables and expressions.
+//
+// The inference is flow-insensitive: the type of a variable is
+// the join of the types of all values assigned to it anywhere in the
+// program, and of its value before the program runs.
+type typeInfo struct {
+	vars  map[string]numType
+	exprs map[node]numType
+}
+
+func inferTypes(n node) *typeInfo {
+	ti := &typeInfo{vars: make(map[string]numType)}
+	for i, v := range runtime.vars.vals {
+		if v.set {
+			ti.vars[runtime.vars.names[i]] = typeOfNumber(v.n)
+		}
+	}
+	for ti.walk(n) {
+	}
+	ti.exprs = make(map[node]numType)
+	return ti
+}
+
+// walk updates the types of variables assigned to in n and reports
+// whether any of them changed.
+func (ti *typeInfo) walk(n node) bool {
+	changed := false
+	switch n := n.(type) {
+	case *assign:
+		t := ti.vars[n.name.s]
+		switch {
+		case n.op.op == nil:
+			t = t.join(ti.of(n.rval))
+		case n.rval != nil:
+			t = t.join(binaryType(n.op.typ, t, ti.of(n.rval)))
+		}
+		changed = t != ti.vars[n.name.s]
+		ti.vars[n.name.s] = t
+	case *forStmt:
+		for _, v := range []node{n.init, n.post, n.body} {
+			if v != nil && ti.walk(v) {
+				changed = true
+			}
+		}
+	case *block:
+		for _, v := range n.stmts {
+			if ti.walk(v) {
+				changed = true
+			}
+		}
+	}
+	return changed
+}
+
+// of returns the type of the expression n.
+func (ti *typeInfo) of(n node) numType {
+	if t, ok := ti.exprs[n]; ok {
+		return t
+	}
+	var t numType
+	switch n := n.(type) {
+	case *numLit:
+		t = typeOfNumber(n.tok.n)
+	case *ident:
+		t = ti.vars[n.tok.s]
+	case *unary:
+		t = typeInt
+		if n.op.typ == '-' {
+			t = ti.of(n.x)
+		}
+	case *paren:
+		t = ti.of(n.x)
+	case *binary:
+		t = binaryType(n.op.typ, ti.of(n.x), ti.of(n.y))
+	}
+	if ti.exprs != nil {
+		ti.exprs[n] = t
+	}
+	return t
+}
+
+// isStatic reports whether x and y are the same static type.
+func isStatic(x, y numType) bool {
+	return x == y && (x == typeInt || x == typeFloat)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+)
+
+var vetFlag = flag.Bool("vet", false,
+	"warn about likely mistakes in programs before running them")
+
+// vetter finds likely mistakes in a program.
+type vetter struct {
+	warnings []*Warning
+	defined  map[string]bool    // variables possibly assigned so far
+	assigned map[string]*assign // first assignment to each variable
+	read     map[string]bool    // variables read anywhere
+}
+
+// vet returns warnings about the program n, sorted by position.
+// Variables that have values before n runs are defined.  If whole
+// is set, n is the whole program, and variables assigned to but never
+// read are reported as well.
+func vet(n node, whole bool) []*Warning {
+	v := &vetter{
+		defined:  make(map[string]bool),
+		assigned: make(map[string]*assign),
+		read:     make(map[string]bool),
+	}
+	for i, val := range runtime.vars.vals {
+		if val.set {
+			v.defined[runtime.vars.names[i]] = true
+		}
+	}
+	v.stmt(n)
+	if whole {
+		for name, a := range v.assigned {
+			if !v.read[name] {
+				v.warn(a.name.pos, a.name.end,
+					"variable %s is assigned but never read", name)
+			}
+		}
+	}
+	sort.SliceStable(v.warnings, func(i, j int) bool {
+		a, b := v.warnings[i].Pos, v.warnings[j].Pos
+		return a.line < b.line || a.line == b.line && a.col < b.col
+	})
+	return v.warnings
+}
+
+func (v *vetter) warnAt(n node, format string, a ...interface{}) {
+	start, end := span(n)
+	v.warn(start, end, format, a...)
+}
+
+func (v *vetter) warn(start, end pos, format string, a ...interface{}) {
+	v.warnings = append(v.warnings, &Warning{
+		Pos: start,
+		End: end,
+		Msg: fmt.Sprintf(format, a...),
+	})
+}
+
+func (v *vetter) stmt(n node) {
+	switch n := n.(type) {
+	case *assign:
+		name := n.name.s
+		if n.rval != nil {
+			v.expr(n.rval)
+		}
+		if n.op.op != nil && !v.defined[name] {
+			v.warn(n.name.pos, n.name.end,
+				"variable %s is updated before it is assigned", name)
+		}
+		if (n.op.typ == DIVEQ || n.op.typ == MODEQ) && isZero(n.rval) {
+			v.warnAt(n, "division by zero")
+		}
+		if v.assigned[name] == nil {
+			v.assigned[name] = n
+		}
+		v.defined[name] = true
+	case *printStmt:
+		v.expr(n.x)
+	case *forStmt:
+		if n.init != nil {
+			v.stmt(n.init)
+		}
+		// the first iteration sees only the variables defined before
+		// the loop, and the post statement those the body assigned to
+		// as well; later iterations see more and warn about nothing new
+		v.expr(n.cond)
+		if a, ok := constValue(n.cond); ok {
+			s := "false"
+			if a.Bool() {
+				s = "true"
+			}
+			v.warnAt(n.cond, "for condition is always %s", s)
+		}
+		v.stmt(n.body)
+		if n.post != nil {
+			v.stmt(n.post)
+		}
+	case *block:
+		for _, s := range n.stmts {
+			v.stmt(s)
+		}
+	}
+}
+
+func (v *vetter) expr(n node) {
+	switch n := n.(type) {
+	case *ident:
+		v.read[n.tok.s] = true
+		if !v.defined[n.tok.s] {
+			v.warnAt(n, "variable %s is read before it is assigned",
+				n.tok.s)
+		}
+	case *unary:
+		v.expr(n.x)
+	case *paren:
+		v.expr(n.x)
+	case *binary:
+		v.expr(n.x)
+		v.expr(n.y)
+		if (n.op.typ == '/' || n.op.typ == '%') && isZero(n.y) {
+			v.warnAt(n, "division by zero")
+		}
+	}
+}
+
+// isZero reports whether n is a number literal that is zero.
+func isZero(n node) bool {
+	a, ok := constNum(n)
+	return ok && !a.Bool()
+}
+
+// constValue returns the value of n if it only has constant operands.
+func constValue(n node) (number, bool) {
+	var isConst func(n node) bool
+	isConst = func(n node) bool {
+		switch n := n.(type) {
+		case *numLit:
+			return true
+		case *unary:
+			return isConst(n.x)
+		case *paren:
+			return isConst(n.x)
+		case *binary:
+			return isConst(n.x) && isConst(n.y)
+		}
+		return false
+	}
+	if !isConst(n) {
+		return number{}, false
+	}
+	var c compiler
+	a, err := evalConst(c.compile(n))
+	return a, err == nil
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// opcode is an instruction of the bytecode virtual machine.
+// The machine has a stack of numbers, and instructions take
+// their operands from the top of the stack.
+type opcode uint8
+
+const (
+	opConst       opcode = iota // push consts[arg]
+	opGet                       // push the variable in slot arg
+	opSet                       // pop into the variable in slot arg
+	opGas                       // charge one operation
+	opUnary                     // apply ops[arg] to the top value
+	opBinary                    // apply ops[arg] to the two top values
+	opDenominator               // fail if the top value is zero
+	opPrint                     // pop and print
+	opAnd                       // jump to arg if top is false, else pop
+	opOr                        // jump to arg if top is true, else pop
+	opJump                      // jump to arg
+	opJumpFalse                 // pop, jump to arg if false
+	opEnter                     // enter a loop
+	opIter                      // count a loop iteration
+	opLeave                     // leave a loop
+	opCall                      // call funs[arg]
+)
+
+var opcodeNames = [...]string{
+	opConst:       "const",
+	opGet:         "get",
+	opSet:         "set",
+	opGas:         "gas",
+	opUnary:       "unary",
+	opBinary:      "binary",
+	opDenominator: "denom",
+	opPrint:       "print",
+	opAnd:         "and",
+	opOr:          "or",
+	opJump:        "jump",
+	opJumpFalse:   "jumpf",
+	opEnter:       "enter",
+	opIter:        "iter",
+	opLeave:       "leave",
+	opCall:        "call",
+}
+
+func (op opcode) String() string {
+	return opcodeNames[op]
+}
+
+// instr is an instruction with the opcode in the low 8 bits
+// and the argument in the rest.
+type instr uint32
+
+func (in instr) op() opcode {
+	return opcode(in & 0xff)
+}
+
+func (in instr) arg() int {
+	return int(in >> 8)
+}
+
+// vmOp is an operator applied by opUnary or opBinary.
+type vmOp struct {
+	s   string
+	un  unOp
+	bin binOp
+}
+
+// bytecode is a program for the virtual machine.
+type bytecode struct {
+	code     []instr
+	where    []*errCtx // where errors of each instruction are reported
+	consts   []number
+	constIdx map[constKey]int // index in consts of each constant
+	ops      []vmOp
+	funs     []fun
+	ctx      *errCtx // innermost statement or division being compiled
+}
+
+// constKey identifies a constant in the constant pool.  Floating point
+// constants are equal if their bits are, as -0.0 == 0.0 but they print
+// differently.
+type constKey struct {
+	isFloat bool
+	i       int
+	f       uint64
+}
+
+// errCtx is a construct errors are reported at, linked to the
+// enclosing one for the trace.
+type errCtx struct {
+	n     node
+	outer *errCtx
+}
+
+// compileVM turns the abstract syntax tree n into bytecode
+// and returns a closure that runs it.
+func compileVM(n node) fun {
+	p := &bytecode{constIdx: make(map[constKey]int)}
+	p.compile(n)
+	if _, ok := n.(*command); !ok && *disasmFlag {
+		p.disasm(os.Stdout)
+	}
+	return p.run
+}
+
+func (p *bytecode) emit(op opcode, arg int) int {
+	return p.emitIn(p.ctx, op, arg)
+}
+
+// emitAt emits an instruction whose errors are reported at n.
+func (p *bytecode) emitAt(n node, op opcode, arg int) int {
+	return p.emitIn(&errCtx{n: n, outer: p.ctx}, op, arg)
+}
+
+// maxArg is the bound of instruction arguments, which have 24 bits.
+const maxArg = 1 << 24
+
+// emitIn emits an instruction whose errors are reported in ctx.
+func (p *bytecode) emitIn(ctx *errCtx, op opcode, arg int) int {
+	if arg >= maxArg {
+		panic("emit: argument too large")
+	}
+	p.code = append(p.code, instr(arg)<<8|instr(op))
+	p.where = append(p.where, ctx)
+	return len(p.code) - 1
+}
+
+// errorAt returns err of the instruction at pc with its position
+// and trace.
+func (p *bytecode) errorAt(pc int, err error) (number, error) {
+	for c := p.where[pc]; c != nil; c = c.outer {
+		err = withPos(c.n, err)
+	}
+	return number{}, err
+}
+
+// patch sets the target of the jump at pc to the next instruction.
+func (p *bytecode) patch(pc int) {
+	if len(p.code) >= maxArg {
+		panic("patch: jump target too large")
+	}
+	p.code[pc] = instr(len(p.code))<<8 | instr(p.code[pc].op())
+}
+
+// emitConst emits a constant, sharing it with an equal one emitted
+// before.
+func (p *bytecode) emitConst(a number) {
+	key := constKey{a.isFloat, a.i, math.Float64bits(a.f)}
+	k, ok := p.constIdx[key]
+	if !ok {
+		k = len(p.consts)
+		p.consts = append(p.consts, a)
+		p.constIdx[key] = k
+	}
+	p.emit(opConst, k)
+}
+
+func (p *bytecode) emitOp(op opcode, o vmOp) {
+	p.ops = append(p.ops, o)
+	p.emit(op, len(p.ops)-1)
+}
+
+func (p *bytecode) compile(n node) {
+	if isErrorContext(n) {
+		defer func(ctx *errCtx) { p.ctx = ctx }(p.ctx)
+		p.ctx = &errCtx{n: n, outer: p.ctx}
+	}
+	switch n := n.(type) {
+	case *numLit:
+		p.emitConst(n.tok.n)
+	case *ident:
+		p.emitAt(n, opGet, runtime.vars.slot(n.tok.s))
+	case *unary:
+		if a, ok := constNum(n); ok {
+			p.emitConst(a)
+			return
+		}
+		p.compile(n.x)
+		p.emitOp(opUnary, vmOp{s: n.op.s, un: vmUnOp(n.op.op)})
+	case *paren:
+		p.compile(n.x)
+	case *binary:
+		if cont, ok := n.op.op.(logicOp); ok {
+			p.compile(n.x)
+			op := opOr
+			if cont {
+				op = opAnd
+			}
+			pc := p.emit(op, 0)
+			p.compile(n.y)
+			p.patch(pc)
+			return
+		}
+		p.binary(n.op, n.x, n.y)
+	case *assign:
+		slot := runtime.vars.slot(n.name.s)
+		switch {
+		case n.op.op == nil:
+			p.compile(n.rval)
+		case n.rval == nil:
+			p.emitAt(&ident{tok: n.name}, opGet, slot)
+			p.emitOp(opUnary, vmOp{s: n.op.s, un: vmUnOp(n.op.op)})
+		default:
+			p.binary(n.op, &ident{tok: n.name}, n.rval)
+		}
+		p.emit(opSet, slot)
+	case *printStmt:
+		p.compile(n.x)
+		p.emit(opPrint, 0)
+	case *forStmt:
+		if n.init != nil {
+			p.compile(n.init)
+		}
+		p.emit(opEnter, 0)
+		top := len(p.code)
+		p.compile(n.cond)
+		pc := p.emit(opJumpFalse, 0)
+		p.emit(opIter, 0)
+		p.compile(n.body)
+		if n.post != nil {
+			p.compile(n.post)
+		}
+		p.emit(opJump, top)
+		p.patch(pc)
+		p.emit(opLeave, 0)
+	case *block:
+		for _, v := range n.stmts {
+			p.compile(v)
+		}
+	case *command:
+		p.funs = append(p.funs, n.tok.fun)
+		p.emit(opCall, len(p.funs)-1)
+	default:
+		panic("compile: unknown node")
+	}
+}
+
+// isErrorContext reports whether errors of n and its subexpressions,
+// except variable references, are reported at n, like at does.
+func isErrorContext(n node) bool {
+	switch n := n.(type) {
+	case *assign, *printStmt, *forStmt:
+		return true
+	case *binary:
+		_, ok := n.op.op.(divModOp)
+		return ok
+	}
+	return false
+}
+
+// binary compiles the binary operator o applied to x and y,
+// charging gas first, like binOp.NewFun does.
+func (p *bytecode) binary(o token, x, y node) {
+	bin, denom := vmBinOp(o.op)
+	p.emit(opGas, 0)
+	p.compile(x)
+	p.compile(y)
+	if denom {
+		p.emit(opDenominator, 0)
+	}
+	p.emitOp(opBinary, vmOp{s: o.s, bin: bin})
+}
+
+// vmBinOp returns the function of the binary operator o and whether
+// it divides by its right operand.
+func vmBinOp(o op) (binOp, bool) {
+	switch o := o.(type) {
+	case arithOp:
+		return o.binOp, false
+	case divModOp:
+		return o.binOp, true
+	case compareOp:
+		return o.BinOp(), false
+	case multiOp:
+		return vmBinOp(o.bin)
+	}
+	panic("vmBinOp: unknown operator")
+}
+
+func vmUnOp(o op) unOp {
+	if m, ok := o.(multiOp); ok {
+		o = m.un
+	}
+	return o.(unOp)
+}
+
+func (p *bytecode) run() (number, error) {
+	var (
+		stack = make([]number, 0, 16)
+		depth int // loops entered
+	)
+	defer func() { runtime.limits.depth.add(-depth) }()
+	for pc := 0; pc < len(p.code); pc++ {
+		in, top := p.code[pc], len(stack)-1
+		switch in.op() {
+		case opConst:
+			stack = append(stack, p.consts[in.arg()])
+		case opGet:
+			a, err := runtime.vars.get(in.arg())
+			if err != nil {
+				return p.errorAt(pc, err)
+			}
+			stack = append(stack, a)
+		case opSet:
+			if err := runtime.vars.set(in.arg(), stack[top]); err != nil {
+				return p.errorAt(pc, err)
+			}
+			stack = stack[:top]
+		case opGas:
+			if err := runtime.limits.gas.add(1); err != nil {
+				return p.errorAt(pc, err)
+			}
+		case opUnary:
+			stack[top] = p.ops[in.arg()].un(stack[top])
+		case opBinary:
+			stack[top-1] = p.ops[in.arg()].bin(stack[top-1], stack[top])
+			stack = stack[:top]
+		case opDenominator:
+			if !stack[top].Bool() {
+				return p.errorAt(pc, ErrZeroDivision)
+			}
+		case opPrint:
+			if err := printNumber(stack[top]); err != nil {
+				return p.errorAt(pc, err)
+			}
+			stack = stack[:top]
+		case opAnd, opOr:
+			if stack[top].Bool() == (in.op() == opOr) {
+				pc = in.arg() - 1
+			} else {
+				stack = stack[:top]
+			}
+		case opJump:
+			pc = in.arg() - 1
+		case opJumpFalse:
+			if !stack[top].Bool() {
+				pc = in.arg() - 1
+			}
+			stack = stack[:top]
+		case opEnter:
+			if err := runtime.limits.depth.add(1); err != nil {
+				return p.errorAt(pc, err)
+			}
+			depth++
+		case opIter:
+			if err := runtime.limits.loops.add(1); err != nil {
+				return p.errorAt(pc, err)
+			}
+		case opLeave:
+			depth--
+			runtime.limits.depth.add(-1)
+		case opCall:
+			if _, err := p.funs[in.arg()](); err != nil {
+				return p.errorAt(pc, err)
+			}
+		}
+	}
+	return number{}, nil
+}
+
+// disasm writes a listing of the program to w.
+func (p *bytecode) disasm(w io.Writer) {
+	for pc, in := range p.code {
+		op, arg := in.op(), in.arg()
+		fmt.Fprintf(w, "%04d\t%s", pc, op)
+		switch op {
+		case opConst:
+			fmt.Fprintf(w, "\t%d\t# %v", arg, p.consts[arg])
+		case opGet, opSet:
+			fmt.Fprintf(w, "\t%d\t# %s", arg, runtime.vars.names[arg])
+		case opUnary, opBinary:
+			fmt.Fprintf(w, "\t%d\t# %s", arg, p.ops[arg].s)
+		case opAnd, opOr, opJump, opJumpFalse:
+			fmt.Fprintf(w, "\t%04d", arg)
+		case opCall:
+			fmt.Fprintf(w, "\t%d", arg)
+		}
+		fmt.Fprintln(w)
+	}
+}